@@ -6,7 +6,8 @@ import { Feed } from './Feed.js'
 import { ApolloClient } from 'apollo-client';
 import { HttpLink } from 'apollo-link-http';
 import { InMemoryCache } from 'apollo-cache-inmemory';
-import { ApolloProvider as ApolloHooksProvider } from '@apollo/react-hooks';
+import { ApolloProvider as ApolloHooksProvider, useQuery } from '@apollo/react-hooks';
+import gql from 'graphql-tag';
 import { ApolloProvider } from 'react-apollo';
 
 const client = new ApolloClient({
@@ -18,21 +19,29 @@ const client = new ApolloClient({
   cache: new InMemoryCache(),
 });
 
-const languages = [
-  "en", "fr", "es", "de", "ru", "ja", "nl", "it", "sv", "pl", "vi", "pt", "ar",
-  "zh", "uk", "ro", "bg", "th", "fa"
-];
+const GET_PROJECTS = gql`
+query Projects {
+  projects {
+    code
+    name
+    localName
+    direction
+  }
+}`;
 
-function langTabs(project, setProject) {
-  return languages.map((lang) => {
-    const onClick = () => {setProject(lang)};
+function LangTabs({ project, setProject }) {
+  const { data } = useQuery(GET_PROJECTS);
+  const projects = (data !== undefined) ? data.projects : [];
+  return projects.map((p) => {
+    const onClick = () => {setProject(p.code)};
     let className = 'LangTab';
-    if (lang === project) {
+    if (p.code === project) {
       className += ' LangTab-selected';
     }
     return (
-      <div className={className} onClick={onClick} key={lang}>
-        {lang}
+      <div className={className} onClick={onClick} key={p.code}
+           title={`${p.name} (${p.localName})`} dir={p.direction}>
+        {p.code}
       </div>
     );
   });
@@ -46,14 +55,14 @@ class App extends React.Component {
     this.setState({project: project});
   }
   render()  {
-    const tabs = langTabs(this.state.project, (project) => { 
+    const setProject = (project) => {
         this.setProject(project);
-    });
+    };
     function App({project}) {
       return (
       <div className="App">
         <div className="LangTabs">
-          {tabs}
+          <LangTabs project={project} setProject={setProject}/>
         </div>
         <div className="AppHeaderContainer">
           <div className="App-header">
@@ -21,11 +21,48 @@ query Feed(
       dailyViews
       imageURL
       thumbnailURL
+      thumbnailWidth
+      thumbnailHeight
+      imageArtist
+      imageLicense
+      imageDescriptionURL
       title
+      entity {
+        classes {
+          label
+        }
+        dates {
+          kind
+          year
+        }
+      }
     }
   }
 }`;
 
+// dateLabels describes the dates of an entity which are shown in its badge.
+const dateLabels = {
+  birth: "born",
+  inception: "founded",
+  point_in_time: "",
+};
+
+// entityBadge summarizes the entity which is the subject of an article, e.g.
+// "Human · born 1962".
+function entityBadge(entity) {
+  if (!entity) return null;
+  const parts = [];
+  if (entity.classes.length > 0 && entity.classes[0].label) {
+    const label = entity.classes[0].label;
+    parts.push(label.charAt(0).toUpperCase() + label.slice(1));
+  }
+  const date = entity.dates.find(({ kind }) => kind in dateLabels);
+  if (date) {
+    parts.push(`${dateLabels[date.kind]} ${date.year}`.trim());
+  }
+  return parts.length > 0 ? parts.join(" · ") : null;
+}
+
 function useFollowerRead() {
     const urlParams = new URLSearchParams(window.location.search);
     return !(urlParams.get("use_follower_read") === "false");
@@ -90,18 +127,33 @@ class FeedContainer extends React.Component {
           dailyViews,
           imageURL,
           thumbnailURL,
-          title
+          thumbnailWidth,
+          thumbnailHeight,
+          imageArtist,
+          imageLicense,
+          imageDescriptionURL,
+          title,
+          entity
         }, idx) => (
         <div className="Article" id={article} key={idx} project={project}>
           <div className="ArticleImageContainer">
             <div className="ArticleImage">
               <a href={articleURL} target="_blank" rel="noopener noreferrer">
-                <img src={thumbnailURL} alt={title}/>
+                <img src={thumbnailURL} alt={title}
+                  width={thumbnailWidth || undefined}
+                  height={thumbnailHeight || undefined}/>
               </a>
+              {imageLicense &&
+                <a className="ImageCredit" href={imageDescriptionURL}
+                  target="_blank" rel="noopener noreferrer">
+                  {[imageArtist, imageLicense].filter(Boolean).join(" · ")}
+                </a>}
             </div>
           </div>
           <div className="ArticleContent">
             <h2 className="ArticleTitle">{title}</h2>
+            {entityBadge(entity) &&
+              <p className="ArticleBadge">{entityBadge(entity)}</p>}
             <p className="ArticleAbstractText">
               {abstract}
             </p>
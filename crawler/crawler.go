@@ -2,119 +2,645 @@ package crawler
 
 import (
 	"context"
+	"expvar"
 	"fmt"
-	"os"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/cockroachlabs/wikifeedia/db"
+	"github.com/cockroachlabs/wikifeedia/imageproxy"
 	"github.com/cockroachlabs/wikifeedia/wikipedia"
 	"golang.org/x/sync/errgroup"
 )
 
 type Crawler struct {
-	db   *db.DB
+	db   db.Store
 	wiki *wikipedia.Client
+
+	viewsGranularity wikipedia.Granularity
+	viewsWindow      time.Duration
+	parallelism      int
+	// prewarmURL is the base URL of a server whose image proxy is warmed
+	// with the images of new articles, if non-empty.
+	prewarmURL string
+	prewarm    *http.Client
+}
+
+// Option configures a Crawler.
+type Option func(*Crawler)
+
+// WithViewHistory configures the crawler to store the views series of each
+// article in the feed with the given granularity over the window preceding
+// the crawled day. A zero window disables the retrieval of views.
+func WithViewHistory(granularity wikipedia.Granularity, window time.Duration) Option {
+	return func(c *Crawler) {
+		c.viewsGranularity = granularity
+		c.viewsWindow = window
+	}
+}
+
+// WithParallelism configures the number of projects which are crawled
+// concurrently.
+func WithParallelism(n int) Option {
+	return func(c *Crawler) {
+		if n > 0 {
+			c.parallelism = n
+		}
+	}
+}
+
+// WithImagePrewarm configures the crawler to request the thumbnail of each
+// new article from the image proxy of the server at baseURL so that it is
+// cached before it is first shown.
+func WithImagePrewarm(baseURL string) Option {
+	return func(c *Crawler) {
+		c.prewarmURL = strings.TrimSuffix(baseURL, "/")
+		c.prewarm = &http.Client{Timeout: time.Minute}
+	}
 }
 
 // New creates a new crawler.
-func New(db *db.DB, wiki *wikipedia.Client) *Crawler {
-	return &Crawler{
-		db:   db,
-		wiki: wiki,
+func New(db db.Store, wiki *wikipedia.Client, opts ...Option) *Crawler {
+	c := &Crawler{
+		db:               db,
+		wiki:             wiki,
+		viewsGranularity: wikipedia.Daily,
+		viewsWindow:      30 * 24 * time.Hour,
+		parallelism:      4,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-// CrawlOnce does one pull of the top list of articles and then fetches them all.
-func (c *Crawler) CrawlOnce(ctx context.Context) error {
-	for _, p := range wikipedia.Projects {
-		if err := c.crawlProjectOnce(ctx, p); err != nil {
-			return err
+// ProjectResult is the outcome of crawling a project.
+type ProjectResult struct {
+	Project  string
+	Duration time.Duration
+	Run      db.CrawlRun
+	Err      error
+}
+
+// Report summarizes the crawl of every project for a day.
+type Report struct {
+	Date    time.Time
+	Results []ProjectResult
+}
+
+// Err returns an error describing the projects which failed, if any.
+func (r *Report) Err() error {
+	var failed []string
+	for _, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", res.Project, res.Err))
 		}
 	}
-	return nil
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to crawl %d of %d projects for %s: %s",
+		len(failed), len(r.Results), r.Date.Format("2006-01-02"), strings.Join(failed, "; "))
 }
 
-func (c *Crawler) crawlProjectOnce(ctx context.Context, project string) (err error) {
-	start := time.Now()
-	defer func() {
+func (r *Report) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "crawl of %s:\n", r.Date.Format("2006-01-02"))
+	for _, res := range r.Results {
+		counts := fmt.Sprintf("fetched %d, skipped %d, failed %d",
+			res.Run.Fetched, res.Run.Skipped, res.Run.Failed)
+		for i, sc := range res.Run.SkipReasons {
+			sep := ", "
+			if i == 0 {
+				sep = "; "
+			}
+			counts += fmt.Sprintf("%s%s %d", sep, sc.Reason, sc.Count)
+		}
+		if res.Err != nil {
+			fmt.Fprintf(&buf, "  %s: failed after %v (%s): %v\n",
+				res.Project, res.Duration, counts, res.Err)
+		} else {
+			fmt.Fprintf(&buf, "  %s: ok in %v (%s)\n", res.Project, res.Duration, counts)
+		}
+	}
+	return buf.String()
+}
+
+// CrawlOnce does one pull of the top list of articles and then fetches them all.
+func (c *Crawler) CrawlOnce(ctx context.Context) (*Report, error) {
+	return c.CrawlDate(ctx, wikipedia.Yesterday())
+}
+
+// CrawlDate pulls the top list of articles of each project of the wikipedia
+// client for the day containing date and then fetches them all. Projects are
+// crawled concurrently and a failure to crawl one project does not prevent
+// the others from being crawled. The returned error is that of the Report.
+func (c *Crawler) CrawlDate(ctx context.Context, date time.Time) (*Report, error) {
+	projects := c.wiki.Projects().Codes()
+	r := &Report{
+		Date:    date.UTC().Truncate(24 * time.Hour),
+		Results: make([]ProjectResult, len(projects)),
+	}
+	sem := make(chan struct{}, c.parallelism)
+	var wg sync.WaitGroup
+	for i, p := range projects {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(res *ProjectResult, project string) {
+			defer func() { <-sem; wg.Done() }()
+			start := time.Now()
+			res.Project = project
+			res.Run, res.Err = c.CrawlProject(ctx, project, date)
+			res.Duration = time.Since(start)
+		}(&r.Results[i], p)
+	}
+	wg.Wait()
+	return r, r.Err()
+}
+
+// CrawlRange crawls each day from from to to inclusive in order. The feed is
+// left reflecting the top articles of the last day. Failures do not stop the
+// crawl of later days.
+func (c *Crawler) CrawlRange(ctx context.Context, from, to time.Time) ([]*Report, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("invalid range: %v is before %v", to, from)
+	}
+	var reports []*Report
+	var failed int
+	for date := from; !date.After(to); date = date.AddDate(0, 0, 1) {
+		r, err := c.CrawlDate(ctx, date)
+		reports = append(reports, r)
 		if err != nil {
-			fmt.Println("crawl of %s took %v", project, time.Since(start))
+			failed++
 		}
+	}
+	if failed > 0 {
+		return reports, fmt.Errorf("failed to crawl %d of %d days", failed, len(reports))
+	}
+	return reports, nil
+}
+
+// CrawlProject pulls the top list of articles of project for the day
+// containing date, fetches them all and removes the articles which are not
+// among them so that the feed reflects that day. The run is recorded in the
+// database whether or not it succeeds.
+func (c *Crawler) CrawlProject(
+	ctx context.Context, project string, date time.Time,
+) (_ db.CrawlRun, err error) {
+	rec := &runRecorder{run: db.CrawlRun{
+		Project: project,
+		// Articles are stamped with the start of the run, which is
+		// truncated to the precision at which the database stores it.
+		Started: time.Now().UTC().Truncate(time.Microsecond),
+		Day:     date.UTC().Truncate(24 * time.Hour),
+	}}
+	defer func() {
+		rec.finish(err)
+		c.recordRun(rec.snapshot(), rec.skippedArticles(), rec.fetchedArticles())
 	}()
-	if err := c.fetchNewTopArticles(ctx, project); err != nil {
-		return err
+	if err := c.fetchNewTopArticles(ctx, project, date, rec); err != nil {
+		return rec.snapshot(), err
+	}
+	// Every article among the top articles was written with a retrieval time
+	// of the start of the run, so the others are left over from other days.
+	err = c.db.DeleteOldArticles(ctx, project, rec.run.Started)
+	return rec.snapshot(), err
+}
+
+// recordRun writes run and the articles it skipped to the database and
+// clears the skips recorded by earlier runs for the same day of the articles
+// it fetched. It uses its own context so that runs which were canceled are
+// recorded too.
+func (c *Crawler) recordRun(run db.CrawlRun, skipped []db.SkippedArticle, fetched []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.db.InsertCrawlRun(ctx, run); err != nil {
+		log.Printf("failed to record crawl run of %s: %v", run.Project, err)
 	}
-	return c.db.DeleteOldArticles(ctx, project,
-		time.Now().UTC().Add(-48*time.Hour).Truncate(24*time.Hour))
+	if err := c.db.UpsertSkippedArticles(ctx, skipped); err != nil {
+		log.Printf("failed to record skipped articles of %s: %v", run.Project, err)
+	}
+	if err := c.db.DeleteSkippedArticles(ctx, run.Project, run.Day, fetched); err != nil {
+		log.Printf("failed to clear skipped articles of %s: %v", run.Project, err)
+	}
+}
+
+// skippedArticles counts the articles skipped by the crawler. Keys are of
+// the form <project>.<reason>.
+var skippedArticles = expvar.NewMap("crawler_skipped_articles")
+
+// runRecorder accumulates a CrawlRun from concurrent goroutines.
+type runRecorder struct {
+	mu      sync.Mutex
+	run     db.CrawlRun
+	skipped []db.SkippedArticle
+	// fetchedTitles are the articles written to the feed.
+	fetchedTitles []string
+}
+
+func (r *runRecorder) fetched(article string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Fetched++
+	r.fetchedTitles = append(r.fetchedTitles, article)
+}
+
+func (r *runRecorder) skip(
+	ta *wikipedia.TopPageviewsArticle, reason db.SkipReason, detail string,
+) {
+	skippedArticles.Add(r.run.Project+"."+string(reason), 1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.AddSkip(reason)
+	r.skipped = append(r.skipped, db.SkippedArticle{
+		Project:  r.run.Project,
+		Day:      r.run.Day,
+		Article:  ta.Article,
+		Reason:   reason,
+		Detail:   detail,
+		Views:    ta.Views,
+		Recorded: time.Now().UTC(),
+	})
 }
 
-func (c *Crawler) fetchNewTopArticles(ctx context.Context, project string) error {
-	top, err := c.wiki.FetchTopArticles(ctx, project)
+func (r *runRecorder) skippedArticles() []db.SkippedArticle {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]db.SkippedArticle(nil), r.skipped...)
+}
+
+func (r *runRecorder) fetchedArticles() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.fetchedTitles...)
+}
+
+func (r *runRecorder) finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Finished = time.Now().UTC()
+	if err != nil {
+		r.run.Error = err.Error()
+	}
+}
+
+func (r *runRecorder) snapshot() db.CrawlRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run := r.run
+	run.SkipReasons = append([]db.SkipCount(nil), r.run.SkipReasons...)
+	return run
+}
+
+func (c *Crawler) fetchNewTopArticles(
+	ctx context.Context, project string, date time.Time, rec *runRecorder,
+) error {
+	top, err := c.wiki.FetchTopArticlesForDate(ctx, project, date, wikipedia.AllAccess)
 	if err != nil {
 		return err
 	}
-	var wg sync.WaitGroup
-	type article struct {
-		ta *wikipedia.TopPageviewsArticle
-		a  *wikipedia.Article
+	for i := range top.Filtered {
+		rec.skip(&top.Filtered[i], db.SkipFiltered, top.Filtered[i].FilterRule)
 	}
-	const writeConcurrency = 10
-	articleChan := make(chan article, writeConcurrency)
-	fetchArticle := func(ta *wikipedia.TopPageviewsArticle) {
+	// Articles already in the feed are only refetched if their content has
+	// changed since they were retrieved.
+	etags, err := c.db.GetArticleETags(ctx, project)
+	if err != nil {
+		return err
+	}
+	fetched := make([]*fetchedArticle, len(top.Articles))
+	var wg sync.WaitGroup
+	fetchArticle := func(i int) {
 		defer wg.Done()
-		a, err := c.wiki.GetArticle(ctx, project, ta.Article)
+		ta := &top.Articles[i]
+		a, changed, err := c.wiki.GetArticleIfChanged(ctx, project, ta.Article, etags[ta.Article])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to retreive %q: %v\n", ta.Article, err)
+			log.Printf("failed to retrieve %q: %v", ta.Article, err)
+			rec.skip(ta, db.SkipFetchError, err.Error())
 			return
 		}
-		articleChan <- article{
-			a:  &a,
-			ta: ta,
+		f := &fetchedArticle{ta: ta}
+		if changed {
+			f.a = &a
+			if c.wiki.Filter().NeedsClasses() {
+				d, err := c.wiki.CheckArticle(ctx, project, &a)
+				if err != nil {
+					log.Printf("failed to check %q: %v", ta.Article, err)
+					rec.skip(ta, db.SkipFetchError, err.Error())
+					return
+				}
+				if !d.Allowed {
+					rec.skip(ta, db.SkipFiltered, d.Rule)
+					return
+				}
+			}
 		}
+		fetched[i] = f
 	}
 	for i := range top.Articles {
 		wg.Add(1)
-		go fetchArticle(&top.Articles[i])
+		go fetchArticle(i)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	go func() { wg.Wait(); close(articleChan) }()
+	// Redirects are fetched as their target so their views are counted
+	// towards it rather than listing the same article more than once.
+	groups := collapseRedirects(fetched)
 	writeGroup, ctx := errgroup.WithContext(ctx)
+	const writeConcurrency = 10
 	sem := make(chan struct{}, writeConcurrency)
-	for a := range articleChan {
-		a := a
-		if a.a.Summary.Extract == "" || len(a.a.Media) == 0 {
-			continue
+	for _, g := range groups {
+		g := g
+		for _, r := range g.redirects {
+			rec.skip(r, db.SkipRedirect, "collapsed onto "+g.ta.Article)
 		}
-		imageURL, ok := a.a.GetImageURL()
-		if !ok {
-			continue
+		if g.a != nil {
+			if reason, ok := skipReason(g.a); ok {
+				rec.skip(&g.ta, reason, "")
+				continue
+			}
 		}
 		select {
 		case sem <- struct{}{}:
 		case <-ctx.Done():
 			return writeGroup.Wait()
 		}
-
 		writeGroup.Go(func() error {
 			defer func() { <-sem }()
-			dba := makeArticle(project, a.ta.Views, a.a, imageURL)
-			return c.db.UpsertArticle(ctx, dba)
+			if g.a == nil {
+				return c.updateArticle(ctx, project, date, &g.ta, rec)
+			}
+			img, err := c.wiki.SelectImage(ctx, project, g.a)
+			if err != nil {
+				log.Printf("failed to select image of %q: %v", g.ta.Article, err)
+				rec.skip(&g.ta, db.SkipFetchError, err.Error())
+				return nil
+			}
+			if img == nil {
+				rec.skip(&g.ta, db.SkipNoImage, "")
+				return nil
+			}
+			views := c.fetchArticleViews(ctx, project, g.ta.Article, date)
+			dba := makeArticle(project, g.ta.Views, g.a, img, rec.run.Started)
+			dba.Trending = trendingScore(g.ta.Views, date, views)
+			if err := c.db.UpsertArticle(ctx, dba); err != nil {
+				return err
+			}
+			if err := c.upsertEntity(ctx, project, dba.Article, g.a); err != nil {
+				return err
+			}
+			c.prewarmImage(ctx, &dba)
+			rec.fetched(dba.Article)
+			return c.db.UpsertArticleViews(ctx, project, g.ta.Article, c.dbGranularity(), views)
 		})
 	}
 	return writeGroup.Wait()
 }
 
-func makeArticle(project string, pageViews int, a *wikipedia.Article, imageURL string) db.Article {
+// fetchedArticle is an article among the top articles which was retrieved.
+type fetchedArticle struct {
+	ta *wikipedia.TopPageviewsArticle
+	// a is the content of the article, or nil if the stored content of the
+	// article is current.
+	a *wikipedia.Article
+}
+
+// canonical returns the title of the article to which f resolves, which
+// differs from the requested title for redirects.
+func (f *fetchedArticle) canonical() string {
+	if f.a != nil && f.a.Summary.Titles.Canonical != "" {
+		return f.a.Summary.Titles.Canonical
+	}
+	return f.ta.Article
+}
+
+// articleGroup is the set of top articles which resolve to the same article.
+type articleGroup struct {
+	// ta describes the canonical article with the views of the whole group.
+	ta wikipedia.TopPageviewsArticle
+	// a is the content of the canonical article, or nil if the stored
+	// content is current.
+	a *wikipedia.Article
+	// redirects are the top articles which redirect to the canonical article.
+	redirects []*wikipedia.TopPageviewsArticle
+}
+
+// collapseRedirects groups fetched, which may contain nils for articles which
+// could not be retrieved, by canonical title so that redirects are counted
+// towards their target. Groups are returned in the order in which their
+// first article appears in fetched.
+func collapseRedirects(fetched []*fetchedArticle) []*articleGroup {
+	var groups []*articleGroup
+	byTitle := make(map[string]*articleGroup)
+	for _, f := range fetched {
+		if f == nil {
+			continue
+		}
+		title := f.canonical()
+		g, ok := byTitle[title]
+		if !ok {
+			g = &articleGroup{ta: wikipedia.TopPageviewsArticle{Article: title, Rank: f.ta.Rank}}
+			byTitle[title] = g
+			groups = append(groups, g)
+		}
+		g.ta.Views += f.ta.Views
+		if f.ta.Article != title {
+			g.redirects = append(g.redirects, f.ta)
+		}
+		// Prefer the content retrieved under the canonical title.
+		if f.a != nil && (g.a == nil || f.ta.Article == title) {
+			g.a = f.a
+		}
+	}
+	return groups
+}
+
+// skipReason returns the reason for which a is left out of the feed, if any.
+// Articles are also left out if none of their images is suitable.
+func skipReason(a *wikipedia.Article) (db.SkipReason, bool) {
+	switch {
+	case a.Summary.Type == "disambiguation":
+		return db.SkipDisambiguation, true
+	case a.Summary.Type == "mainpage":
+		return db.SkipMainPage, true
+	case a.Summary.Extract == "":
+		return db.SkipNoExtract, true
+	}
+	return "", false
+}
+
+// updateArticle refreshes the views and trending score of an article whose
+// content has not changed since it was stored.
+func (c *Crawler) updateArticle(
+	ctx context.Context, project string, date time.Time, ta *wikipedia.TopPageviewsArticle, rec *runRecorder,
+) error {
+	views := c.fetchArticleViews(ctx, project, ta.Article, date)
+	trending := trendingScore(ta.Views, date, views)
+	updated, err := c.db.UpdateArticleViews(ctx, project, ta.Article, ta.Views, trending, rec.run.Started)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		// The article was deleted after its ETag was read; it will be
+		// fetched again by the next crawl.
+		rec.skip(ta, db.SkipFetchError, "article deleted during crawl")
+		return nil
+	}
+	rec.fetched(ta.Article)
+	return c.db.UpsertArticleViews(ctx, project, ta.Article, c.dbGranularity(), views)
+}
+
+func makeArticle(
+	project string, pageViews int, a *wikipedia.Article, img *wikipedia.ImageInfo, retrieved time.Time,
+) db.Article {
+	article := a.Article
+	if canonical := a.Summary.Titles.Canonical; canonical != "" {
+		article = canonical
+	}
 	dba := db.Article{
-		Project:      project,
-		Article:      a.Article,
-		Title:        a.Summary.Titles.Normalized,
-		Abstract:     a.Summary.Extract,
-		DailyViews:   pageViews,
-		ArticleURL:   a.Summary.ContentURLs.Desktop.Page,
-		Retrieved:    a.Summary.Timestamp,
-		ImageURL:     imageURL,
-		ThumbnailURL: imageURL,
+		Project:    project,
+		Article:    article,
+		Title:      a.Summary.Titles.Normalized,
+		Abstract:   a.Summary.Extract,
+		DailyViews: pageViews,
+		ArticleURL: a.Summary.ContentURLs.Desktop.Page,
+		Retrieved:  retrieved,
+		ETag:       a.Summary.ETag,
+
+		ImageURL:                 img.URL,
+		ImageWidth:               img.Width,
+		ImageHeight:              img.Height,
+		ThumbnailURL:             img.ThumbnailURL,
+		ThumbnailWidth:           img.ThumbnailWidth,
+		ThumbnailHeight:          img.ThumbnailHeight,
+		ImageLicense:             img.License,
+		ImageLicenseURL:          img.LicenseURL,
+		ImageArtist:              img.Artist,
+		ImageAttributionRequired: img.AttributionRequired,
+		ImageDescriptionURL:      img.DescriptionURL,
 	}
 	return dba
 }
+
+// prewarmImage requests the thumbnail of a from the image proxy, if
+// configured. The response is discarded: the request only serves to fill the
+// cache of the proxy, which otherwise fetches the image when it is first
+// shown.
+func (c *Crawler) prewarmImage(ctx context.Context, a *db.Article) {
+	if c.prewarmURL == "" || a.ImageURL == "" {
+		return
+	}
+	req, err := http.NewRequest(http.MethodGet,
+		c.prewarmURL+imageproxy.Path(a.ImageURL, a.ThumbnailWidth), nil)
+	if err != nil {
+		log.Printf("failed to prewarm image of %q: %v", a.Article, err)
+		return
+	}
+	resp, err := c.prewarm.Do(req.WithContext(ctx))
+	if err != nil {
+		log.Printf("failed to prewarm image of %q: %v", a.Article, err)
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("failed to prewarm image of %q: status %d", a.Article, resp.StatusCode)
+	}
+}
+
+// upsertEntity stores the Wikidata entity which is the subject of a, if any.
+// An article whose entity cannot be retrieved stays in the feed but is not
+// matched by filters on the class of its subject.
+func (c *Crawler) upsertEntity(
+	ctx context.Context, project, article string, a *wikipedia.Article,
+) error {
+	item := a.Summary.WikibaseItem
+	if item == "" {
+		return nil
+	}
+	_, language := wikipedia.SplitProject(project)
+	e, err := c.wiki.GetWikidataEntity(ctx, item, language)
+	if err != nil {
+		log.Printf("failed to retrieve entity of %q: %v", article, err)
+		return nil
+	}
+	return c.db.UpsertArticleEntity(ctx, project, article, makeEntity(e))
+}
+
+func makeEntity(e *wikipedia.Entity) db.Entity {
+	dbe := db.Entity{
+		Item:        e.ID,
+		Description: e.Description,
+	}
+	for _, c := range e.Classes {
+		dbe.Classes = append(dbe.Classes, db.EntityClass{ID: c.ID, Label: c.Label})
+	}
+	if e.Coordinates != nil {
+		dbe.Coordinates = &db.Coordinates{
+			Latitude:  e.Coordinates.Latitude,
+			Longitude: e.Coordinates.Longitude,
+		}
+	}
+	for _, d := range e.Dates {
+		dbe.Dates = append(dbe.Dates, db.EntityDate{Kind: d.Kind, Date: d.Date, Year: d.Year})
+	}
+	if e.Image != "" {
+		dbe.ImageURL = wikipedia.CommonsFileURL(e.Image)
+	}
+	return dbe
+}
+
+// fetchArticleViews retrieves the views series of article over the configured
+// window ending on the day containing date. It returns nil if the series is
+// disabled or unavailable, in which case the trending score of the article
+// is computed against an empty history.
+func (c *Crawler) fetchArticleViews(
+	ctx context.Context, project, article string, date time.Time,
+) []db.ArticleViews {
+	if c.viewsWindow <= 0 {
+		return nil
+	}
+	to := date.UTC().Truncate(24 * time.Hour)
+	if c.viewsGranularity == wikipedia.Hourly {
+		to = to.Add(23 * time.Hour)
+	}
+	from := to.Add(-c.viewsWindow)
+	views, err := c.wiki.FetchArticleViews(ctx, project, article, c.viewsGranularity, from, to)
+	if err != nil {
+		log.Printf("failed to retrieve views of %q: %v", article, err)
+		return nil
+	}
+	dbViews, err := makeArticleViews(views)
+	if err != nil {
+		log.Printf("failed to parse views of %q: %v", article, err)
+		return nil
+	}
+	return dbViews
+}
+
+// dbGranularity returns the db.Granularity at which views are stored.
+func (c *Crawler) dbGranularity() db.Granularity {
+	if c.viewsGranularity == wikipedia.Hourly {
+		return db.Hourly
+	}
+	return db.Daily
+}
+
+func makeArticleViews(views []wikipedia.ArticleViews) ([]db.ArticleViews, error) {
+	dbViews := make([]db.ArticleViews, 0, len(views))
+	for _, v := range views {
+		ts, err := v.Time()
+		if err != nil {
+			return nil, err
+		}
+		dbViews = append(dbViews, db.ArticleViews{Timestamp: ts, Views: v.Views})
+	}
+	return dbViews, nil
+}
@@ -0,0 +1,216 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cockroachlabs/wikifeedia/db"
+	"github.com/cockroachlabs/wikifeedia/wikipedia"
+	"github.com/cockroachlabs/wikifeedia/wikipedia/wikipediatest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	r := &Report{
+		Date: time.Date(2019, 7, 4, 0, 0, 0, 0, time.UTC),
+		Results: []ProjectResult{
+			{Project: "en", Duration: time.Second, Run: db.CrawlRun{
+				Fetched: 10, Skipped: 2, Failed: 1,
+				SkipReasons: []db.SkipCount{
+					{Reason: db.SkipNoImage, Count: 2},
+					{Reason: db.SkipFetchError, Count: 1},
+				},
+			}},
+			{Project: "fa", Duration: time.Second, Err: errors.New("boom")},
+			{Project: "th", Duration: time.Second},
+		},
+	}
+	assert.EqualError(t, r.Err(), "failed to crawl 1 of 3 projects for 2019-07-04: fa: boom")
+	assert.Contains(t, r.String(), "fa: failed after 1s (fetched 0, skipped 0, failed 0): boom")
+	assert.Contains(t, r.String(), "th: ok in 1s")
+	assert.Contains(t, r.String(),
+		"en: ok in 1s (fetched 10, skipped 2, failed 1; no_image 2, fetch_error 1)")
+
+	r.Results[1].Err = nil
+	assert.Nil(t, r.Err())
+}
+
+func TestCollapseRedirects(t *testing.T) {
+	article := func(title, canonical string) *wikipedia.Article {
+		a := &wikipedia.Article{Article: title}
+		a.Summary.Titles.Canonical = canonical
+		return a
+	}
+	fetched := []*fetchedArticle{
+		{ta: &wikipedia.TopPageviewsArticle{Article: "Foo_(band)", Views: 100, Rank: 1},
+			a: article("Foo_(band)", "Foo")},
+		nil,
+		{ta: &wikipedia.TopPageviewsArticle{Article: "Bar", Views: 80, Rank: 3}},
+		{ta: &wikipedia.TopPageviewsArticle{Article: "Foo", Views: 50, Rank: 4},
+			a: article("Foo", "Foo")},
+		{ta: &wikipedia.TopPageviewsArticle{Article: "Baz", Views: 10, Rank: 5},
+			a: article("Baz", "Bar")},
+	}
+	groups := collapseRedirects(fetched)
+	require.Len(t, groups, 2)
+
+	assert.Equal(t, wikipedia.TopPageviewsArticle{Article: "Foo", Views: 150, Rank: 1}, groups[0].ta)
+	assert.Equal(t, fetched[3].a, groups[0].a)
+	assert.Equal(t, []*wikipedia.TopPageviewsArticle{fetched[0].ta}, groups[0].redirects)
+
+	assert.Equal(t, wikipedia.TopPageviewsArticle{Article: "Bar", Views: 90, Rank: 3}, groups[1].ta)
+	assert.Equal(t, fetched[4].a, groups[1].a)
+	assert.Equal(t, []*wikipedia.TopPageviewsArticle{fetched[4].ta}, groups[1].redirects)
+}
+
+func TestSkipReason(t *testing.T) {
+	for _, tc := range []struct {
+		typ, extract string
+		reason       db.SkipReason
+	}{
+		{typ: "standard", extract: "text"},
+		{typ: "disambiguation", extract: "text", reason: db.SkipDisambiguation},
+		{typ: "mainpage", extract: "text", reason: db.SkipMainPage},
+		{typ: "standard", reason: db.SkipNoExtract},
+	} {
+		a := &wikipedia.Article{}
+		a.Summary.Type = tc.typ
+		a.Summary.Extract = tc.extract
+		reason, ok := skipReason(a)
+		assert.Equal(t, tc.reason != "", ok, tc.typ)
+		assert.Equal(t, tc.reason, reason, tc.typ)
+	}
+}
+
+func TestMakeEntity(t *testing.T) {
+	e := makeEntity(&wikipedia.Entity{
+		ID:          "Q243",
+		Description: "tower in Paris",
+		Classes:     []wikipedia.EntityClass{{ID: "Q1440476", Label: "lattice tower"}},
+		Coordinates: &wikipedia.Coordinates{Latitude: 48.8583, Longitude: 2.2944},
+		Dates:       []wikipedia.EntityDate{{Kind: "inception", Date: "1889-03-31", Year: 1889}},
+		Image:       "Tour Eiffel.jpg",
+	})
+	assert.Equal(t, db.Entity{
+		Item:        "Q243",
+		Description: "tower in Paris",
+		Classes:     []db.EntityClass{{ID: "Q1440476", Label: "lattice tower"}},
+		Coordinates: &db.Coordinates{Latitude: 48.8583, Longitude: 2.2944},
+		Dates:       []db.EntityDate{{Kind: "inception", Date: "1889-03-31", Year: 1889}},
+		ImageURL:    "https://commons.wikimedia.org/wiki/Special:FilePath/Tour_Eiffel.jpg",
+	}, e)
+	assert.Equal(t, db.Entity{Item: "Q1"}, makeEntity(&wikipedia.Entity{ID: "Q1"}))
+}
+
+func TestCrawlProject(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "Foo"), 200)
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "Bar"), 100)
+	srv.AddTopArticle("en", "Missing", 50)
+	store := db.NewMemStore()
+	c := New(store, srv.NewClient(), WithViewHistory(wikipedia.Daily, 0))
+	ctx := context.Background()
+	run, err := c.CrawlProject(ctx, "en", wikipedia.Yesterday())
+	require.Nil(t, err)
+	assert.Equal(t, 2, run.Fetched)
+	assert.Equal(t, 1, run.Failed)
+
+	articles, _, err := store.GetArticles(ctx, "en", 0, 10, db.OrderByViews, "", false, "")
+	require.Nil(t, err)
+	require.Len(t, articles, 2)
+	assert.Equal(t, "Foo", articles[0].Article)
+	assert.Equal(t, 200, articles[0].DailyViews)
+	assert.Equal(t, "Bar", articles[1].Article)
+	runs, err := store.GetCrawlRuns(ctx, "en", 10)
+	require.Nil(t, err)
+	assert.Len(t, runs, 1)
+	skipped, err := store.GetSkippedArticles(ctx, "en", run.Day, db.SkipFetchError, 10)
+	require.Nil(t, err)
+	if assert.Len(t, skipped, 1) {
+		assert.Equal(t, "Missing", skipped[0].Article)
+	}
+
+	// The skip is cleared once a later crawl of the day fetches the article.
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "Missing"), 50)
+	_, err = c.CrawlProject(ctx, "en", run.Day)
+	require.Nil(t, err)
+	skipped, err = store.GetSkippedArticles(ctx, "en", run.Day, "", 10)
+	require.Nil(t, err)
+	assert.Len(t, skipped, 0)
+}
+
+func TestCrawlProjectReplacesFeed(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "Foo"), 200)
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "Bar"), 100)
+	store := db.NewMemStore()
+	c := New(store, srv.NewClient(), WithViewHistory(wikipedia.Daily, 0))
+	ctx := context.Background()
+	day := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	_, err := c.CrawlProject(ctx, "en", day)
+	require.Nil(t, err)
+
+	srv.ClearTopArticles("en")
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "Baz"), 300)
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "Bar"), 50)
+	_, err = c.CrawlProject(ctx, "en", day.AddDate(0, 0, 1))
+	require.Nil(t, err)
+
+	articles, _, err := store.GetArticles(ctx, "en", 0, 10, db.OrderByViews, "", false, "")
+	require.Nil(t, err)
+	var titles []string
+	for _, a := range articles {
+		titles = append(titles, a.Article)
+	}
+	assert.Equal(t, []string{"Baz", "Bar"}, titles)
+}
+
+func TestCrawlProjectUnchangedArticles(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "Foo"), 200)
+	store := db.NewMemStore()
+	c := New(store, srv.NewClient(), WithViewHistory(wikipedia.Daily, 0))
+	ctx := context.Background()
+	day := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	_, err := c.CrawlProject(ctx, "en", day)
+	require.Nil(t, err)
+	require.Equal(t, 1, srv.Requests("/page/summary/Foo"))
+	require.Equal(t, 1, srv.Requests("/page/media-list/Foo"))
+
+	// The content of Foo is unchanged, so only its views are refreshed. It
+	// stays in the feed of the next day only if its retrieval time is
+	// updated too.
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "Foo"), 300)
+	run, err := c.CrawlProject(ctx, "en", day.AddDate(0, 0, 1))
+	require.Nil(t, err)
+	assert.Equal(t, 1, run.Fetched)
+	assert.Equal(t, 2, srv.Requests("/page/summary/Foo"))
+	assert.Equal(t, 1, srv.NotModified())
+	assert.Equal(t, 1, srv.Requests("/page/media-list/Foo"))
+	articles, _, err := store.GetArticles(ctx, "en", 0, 10, db.OrderByViews, "", false, "")
+	require.Nil(t, err)
+	require.Len(t, articles, 1)
+	assert.Equal(t, 300, articles[0].DailyViews)
+	assert.Equal(t, "An article about Foo.", articles[0].Abstract)
+
+	// A new revision changes the ETag, so the article is fetched again.
+	a := wikipediatest.MakeArticle("en", "Foo")
+	a.Summary.Revision = "2"
+	a.Summary.Extract = "A revised article about Foo."
+	srv.AddArticle("en", a, 400)
+	_, err = c.CrawlProject(ctx, "en", day.AddDate(0, 0, 2))
+	require.Nil(t, err)
+	assert.Equal(t, 1, srv.NotModified())
+	assert.Equal(t, 2, srv.Requests("/page/media-list/Foo"))
+	articles, _, err = store.GetArticles(ctx, "en", 0, 10, db.OrderByViews, "", false, "")
+	require.Nil(t, err)
+	require.Len(t, articles, 1)
+	assert.Equal(t, 400, articles[0].DailyViews)
+	assert.Equal(t, "A revised article about Foo.", articles[0].Abstract)
+}
@@ -0,0 +1,245 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachlabs/wikifeedia/wikipedia"
+)
+
+// ScheduleConfig configures a Scheduler.
+type ScheduleConfig struct {
+	// Projects is the set of projects to crawl.
+	Projects []string
+	// Interval is the time between the starts of consecutive crawls of a
+	// project.
+	Interval time.Duration
+	// ProjectIntervals overrides Interval for individual projects.
+	ProjectIntervals map[string]time.Duration
+	// Jitter is the upper bound of a random delay added to each interval so
+	// that crawls of different projects are spread out.
+	Jitter time.Duration
+	// MaxRunTime bounds the duration of each crawl of a project. Zero means
+	// no bound.
+	MaxRunTime time.Duration
+	// StaleAfter is the age of the last successful crawl of a project after
+	// which it is reported as stale. Zero defaults to three intervals.
+	StaleAfter time.Duration
+	// Parallelism bounds the number of projects which are crawled
+	// concurrently. Zero defaults to the parallelism of the Crawler.
+	Parallelism int
+}
+
+func (cfg *ScheduleConfig) interval(project string) time.Duration {
+	if d, ok := cfg.ProjectIntervals[project]; ok {
+		return d
+	}
+	return cfg.Interval
+}
+
+func (cfg *ScheduleConfig) staleAfter(project string) time.Duration {
+	if cfg.StaleAfter > 0 {
+		return cfg.StaleAfter
+	}
+	return 3 * cfg.interval(project)
+}
+
+// ProjectStatus describes the crawls of a project run by a Scheduler.
+type ProjectStatus struct {
+	Project     string    `json:"project"`
+	Running     bool      `json:"running"`
+	Stale       bool      `json:"stale"`
+	LastStart   time.Time `json:"last_start"`
+	LastEnd     time.Time `json:"last_end"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	NextStart   time.Time `json:"next_start"`
+}
+
+// Scheduler periodically crawls each project.
+type Scheduler struct {
+	cfg     ScheduleConfig
+	crawl   func(ctx context.Context, project string) error
+	started time.Time
+	// sem bounds the number of concurrent crawls.
+	sem chan struct{}
+
+	mu       sync.Mutex
+	statuses map[string]*ProjectStatus
+}
+
+// NewScheduler creates a Scheduler which crawls the top articles of
+// yesterday using c.
+func NewScheduler(c *Crawler, cfg ScheduleConfig) *Scheduler {
+	if cfg.Parallelism <= 0 {
+		cfg.Parallelism = c.parallelism
+	}
+	return newScheduler(cfg, func(ctx context.Context, project string) error {
+		_, err := c.CrawlProject(ctx, project, wikipedia.Yesterday())
+		return err
+	})
+}
+
+func newScheduler(
+	cfg ScheduleConfig, crawl func(ctx context.Context, project string) error,
+) *Scheduler {
+	s := &Scheduler{
+		cfg:      cfg,
+		crawl:    crawl,
+		statuses: make(map[string]*ProjectStatus, len(cfg.Projects)),
+	}
+	if cfg.Parallelism > 0 {
+		s.sem = make(chan struct{}, cfg.Parallelism)
+	}
+	for _, p := range cfg.Projects {
+		s.statuses[p] = &ProjectStatus{Project: p}
+	}
+	return s
+}
+
+// Run crawls each project on its schedule until ctx is canceled. Crawls in
+// progress when ctx is canceled are abandoned.
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.mu.Lock()
+	s.started = time.Now()
+	s.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, p := range s.cfg.Projects {
+		wg.Add(1)
+		go func(project string) {
+			defer wg.Done()
+			s.runProject(ctx, project)
+		}(p)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (s *Scheduler) runProject(ctx context.Context, project string) {
+	next := time.Now().Add(s.jitter())
+	for {
+		s.update(project, func(st *ProjectStatus) { st.NextStart = next })
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			return
+		}
+		if s.sem != nil {
+			select {
+			case s.sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		start := time.Now()
+		s.update(project, func(st *ProjectStatus) {
+			st.Running = true
+			st.LastStart = start
+		})
+		err := s.crawlOnce(ctx, project)
+		if s.sem != nil {
+			<-s.sem
+		}
+		end := time.Now()
+		s.update(project, func(st *ProjectStatus) {
+			st.Running = false
+			st.LastEnd = end
+			if err != nil {
+				st.LastError = err.Error()
+			} else {
+				st.LastError = ""
+				st.LastSuccess = end
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("crawl of %s failed after %v: %v", project, end.Sub(start), err)
+		}
+		next = start.Add(s.cfg.interval(project) + s.jitter())
+	}
+}
+
+func (s *Scheduler) crawlOnce(ctx context.Context, project string) (err error) {
+	if s.cfg.MaxRunTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.MaxRunTime)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return s.crawl(ctx, project)
+}
+
+func (s *Scheduler) jitter() time.Duration {
+	if s.cfg.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.cfg.Jitter)))
+}
+
+func (s *Scheduler) update(project string, f func(*ProjectStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s.statuses[project])
+}
+
+// Status returns the status of each project sorted by project.
+func (s *Scheduler) Status() []ProjectStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	ret := make([]ProjectStatus, 0, len(s.statuses))
+	for _, st := range s.statuses {
+		st := *st
+		// Projects which have never succeeded are measured from the start of
+		// the scheduler.
+		since := st.LastSuccess
+		if since.IsZero() {
+			since = s.started
+		}
+		st.Stale = !since.IsZero() && now.Sub(since) > s.cfg.staleAfter(st.Project)
+		ret = append(ret, st)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Project < ret[j].Project })
+	return ret
+}
+
+// ServeHTTP serves /healthz, which reports that the process is alive,
+// /status, which reports the status of each project as JSON and responds
+// with 503 Service Unavailable if any project is stale, and /debug/vars,
+// which exports the counters of the crawler.
+func (s *Scheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		if _, err := w.Write([]byte("OK")); err != nil {
+			log.Printf("could not write response: %v", err)
+		}
+	case "/status":
+		statuses := s.Status()
+		w.Header().Set("Content-Type", "application/json")
+		for _, st := range statuses {
+			if st.Stale {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				break
+			}
+		}
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			log.Printf("could not write response: %v", err)
+		}
+	case "/debug/vars":
+		expvar.Handler().ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
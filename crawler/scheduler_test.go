@@ -0,0 +1,99 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler(t *testing.T) {
+	var mu sync.Mutex
+	crawls := make(map[string]int)
+	crawl := func(ctx context.Context, project string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		crawls[project]++
+		switch project {
+		case "fr":
+			return errors.New("boom")
+		case "de":
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}
+	s := newScheduler(ScheduleConfig{
+		Projects:         []string{"en", "fr", "de"},
+		Interval:         10 * time.Millisecond,
+		ProjectIntervals: map[string]time.Duration{"fr": time.Hour},
+		MaxRunTime:       5 * time.Millisecond,
+		StaleAfter:       50 * time.Millisecond,
+	}, crawl)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Run(ctx))
+
+	mu.Lock()
+	assert.True(t, crawls["en"] > 5)
+	assert.Equal(t, 1, crawls["fr"])
+	assert.True(t, crawls["de"] > 5)
+	mu.Unlock()
+
+	statuses := s.Status()
+	require.Len(t, statuses, 3)
+	de, en, fr := statuses[0], statuses[1], statuses[2]
+	assert.False(t, en.LastSuccess.IsZero())
+	assert.False(t, en.Stale)
+	assert.Equal(t, "boom", fr.LastError)
+	assert.True(t, fr.Stale)
+	assert.True(t, de.LastSuccess.IsZero())
+	assert.Equal(t, context.DeadlineExceeded.Error(), de.LastError)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/status", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var got []ProjectStatus
+	require.Nil(t, json.NewDecoder(rec.Body).Decode(&got))
+	assert.Len(t, got, 3)
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestSchedulerParallelism(t *testing.T) {
+	var mu sync.Mutex
+	var running, maxRunning int
+	crawl := func(ctx context.Context, project string) error {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	}
+	s := newScheduler(ScheduleConfig{
+		Projects:    []string{"en", "fr", "de", "es", "it"},
+		Interval:    time.Millisecond,
+		Parallelism: 2,
+	}, crawl)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, maxRunning)
+}
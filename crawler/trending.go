@@ -0,0 +1,36 @@
+package crawler
+
+import (
+	"math"
+	"time"
+
+	"github.com/cockroachlabs/wikifeedia/db"
+)
+
+const (
+	// trendingBaselineDays is the number of days preceding the crawled day
+	// whose mean daily views form the baseline of the trending score.
+	trendingBaselineDays = 7
+	// trendingSmoothing dampens the score of articles with few views.
+	trendingSmoothing = 100
+)
+
+// trendingScore computes how unusual views on the day containing date are
+// relative to the mean daily views over the preceding days in history. The
+// score is the excess over the baseline scaled by its square root so that
+// articles which are always popular score near zero while articles which
+// suddenly gain attention score highly. Days missing from history count as
+// having no views.
+func trendingScore(views int, date time.Time, history []db.ArticleViews) float64 {
+	day := date.UTC().Truncate(24 * time.Hour)
+	baselineStart := day.AddDate(0, 0, -trendingBaselineDays)
+	var total int
+	for _, v := range history {
+		ts := v.Timestamp.UTC()
+		if !ts.Before(baselineStart) && ts.Before(day) {
+			total += v.Views
+		}
+	}
+	baseline := float64(total) / trendingBaselineDays
+	return (float64(views) - baseline) / math.Sqrt(baseline+trendingSmoothing)
+}
@@ -0,0 +1,37 @@
+package crawler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cockroachlabs/wikifeedia/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrendingScore(t *testing.T) {
+	day := time.Date(2019, 7, 10, 0, 0, 0, 0, time.UTC)
+	steady := func(views int) []db.ArticleViews {
+		var history []db.ArticleViews
+		for i := 1; i <= 10; i++ {
+			history = append(history, db.ArticleViews{
+				Timestamp: day.AddDate(0, 0, -i),
+				Views:     views,
+			})
+		}
+		// Views on the crawled day itself are not part of the baseline.
+		return append(history, db.ArticleViews{Timestamp: day, Views: 1e9})
+	}
+	evergreen := trendingScore(100000, day, steady(100000))
+	assert.InDelta(t, 0, evergreen, 1e-9)
+
+	spike := trendingScore(50000, day, steady(1000))
+	assert.True(t, spike > evergreen)
+
+	// An article without history trends in proportion to its views.
+	fresh := trendingScore(50000, day, nil)
+	assert.True(t, fresh > spike)
+	assert.True(t, trendingScore(100, day, nil) < fresh)
+
+	// Declining articles have a negative score.
+	assert.True(t, trendingScore(1000, day, steady(50000)) < 0)
+}
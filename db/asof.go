@@ -0,0 +1,97 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxAsOfAge bounds how far in the past articles may be read. It is within
+// the default GC TTL of CockroachDB, 25 hours, after which past versions of
+// rows are no longer retained.
+const MaxAsOfAge = 24 * time.Hour
+
+// maxClockOffset tolerates timestamps which are slightly ahead of the clock
+// of this process because they were issued by a node with a faster clock. It
+// is the default maximum clock offset of CockroachDB.
+const maxClockOffset = 500 * time.Millisecond
+
+// InvalidAsOfError is returned for times as of which articles may not be
+// read because they are malformed or out of range.
+type InvalidAsOfError struct {
+	AsOf   string
+	Reason string
+}
+
+func (e *InvalidAsOfError) Error() string {
+	return fmt.Sprintf("invalid asOf %q: %s", e.AsOf, e.Reason)
+}
+
+// hlc is a hybrid logical timestamp like those of CockroachDB.
+type hlc struct {
+	wall    int64
+	logical int32
+}
+
+func (t hlc) less(o hlc) bool {
+	return t.wall < o.wall || (t.wall == o.wall && t.logical < o.logical)
+}
+
+// String formats t like cluster_logical_timestamp(). The result consists
+// only of digits and a decimal point.
+func (t hlc) String() string {
+	return fmt.Sprintf("%d.%010d", t.wall, t.logical)
+}
+
+// hlcDecimal matches timestamps formatted like cluster_logical_timestamp().
+var hlcDecimal = regexp.MustCompile(`^[0-9]{1,19}(\.[0-9]{1,10})?$`)
+
+// parseAsOf parses and validates a time as of which to read. It accepts
+// decimal timestamps as returned by cluster_logical_timestamp() and RFC 3339
+// times. Times which are more than MaxAsOfAge before now or which are after
+// now are rejected. Errors are of type *InvalidAsOfError.
+func parseAsOf(s string, now time.Time) (hlc, error) {
+	ts, err := parseTimestamp(s)
+	if err != nil {
+		return hlc{}, err
+	}
+	if t := time.Unix(0, ts.wall); t.Before(now.Add(-MaxAsOfAge)) {
+		return hlc{}, &InvalidAsOfError{AsOf: s,
+			Reason: fmt.Sprintf("more than %v in the past", MaxAsOfAge)}
+	} else if t.After(now.Add(maxClockOffset)) {
+		return hlc{}, &InvalidAsOfError{AsOf: s, Reason: "in the future"}
+	}
+	return ts, nil
+}
+
+// parseTimestamp parses a decimal or RFC 3339 timestamp.
+func parseTimestamp(s string) (hlc, error) {
+	if hlcDecimal.MatchString(s) {
+		parts := strings.SplitN(s, ".", 2)
+		wall, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return hlc{}, &InvalidAsOfError{AsOf: s, Reason: "out of range"}
+		}
+		ts := hlc{wall: wall}
+		if len(parts) == 2 {
+			// The fraction is the logical part scaled to ten digits, so
+			// "123.5" has a logical part of 5000000000.
+			frac := parts[1] + strings.Repeat("0", 10-len(parts[1]))
+			logical, err := strconv.ParseInt(frac, 10, 64)
+			if err != nil || logical > math.MaxInt32 {
+				return hlc{}, &InvalidAsOfError{AsOf: s, Reason: "out of range"}
+			}
+			ts.logical = int32(logical)
+		}
+		return ts, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return hlc{}, &InvalidAsOfError{AsOf: s,
+			Reason: "not a decimal timestamp or an RFC 3339 time"}
+	}
+	return hlc{wall: t.UnixNano()}, nil
+}
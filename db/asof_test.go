@@ -0,0 +1,53 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAsOf(t *testing.T) {
+	now := time.Date(2019, 10, 2, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		asOf string
+		exp  hlc
+		err  string
+	}{
+		{asOf: "1570017600000000000.0000000001", exp: hlc{wall: now.UnixNano(), logical: 1}},
+		{asOf: "1570017600000000000.0000000012", exp: hlc{wall: now.UnixNano(), logical: 12}},
+		{asOf: "1570017600000000000.0", exp: hlc{wall: now.UnixNano()}},
+		{asOf: "1570017600000000000.00000001", exp: hlc{wall: now.UnixNano(), logical: 100}},
+		{asOf: "1570017600000000000.2", exp: hlc{wall: now.UnixNano(), logical: 2000000000}},
+		{asOf: "1570017600000000000", exp: hlc{wall: now.UnixNano()}},
+		{asOf: "2019-10-02T11:00:00Z", exp: hlc{wall: now.Add(-time.Hour).UnixNano()}},
+		{asOf: "2019-10-02T13:00:00.5+01:00", exp: hlc{wall: now.Add(500 * time.Millisecond).UnixNano()}},
+		{asOf: "", err: "not a decimal timestamp"},
+		{asOf: "-10s", err: "not a decimal timestamp"},
+		{asOf: "1570017600000000000' OR 1=1 --", err: "not a decimal timestamp"},
+		{asOf: "1570017600000000000.9999999999", err: "out of range"},
+		{asOf: "1570017600000000000.5", err: "out of range"},
+		{asOf: "1570017600000000000.2147483648", err: "out of range"},
+		{asOf: "9999999999999999999", err: "out of range"},
+		{asOf: "2019-10-01T11:00:00Z", err: "in the past"},
+		{asOf: "2019-10-02T12:00:01Z", err: "in the future"},
+	} {
+		ts, err := parseAsOf(tc.asOf, now)
+		if tc.err != "" {
+			if assert.IsType(t, &InvalidAsOfError{}, err, tc.asOf) {
+				assert.Contains(t, err.Error(), tc.err, tc.asOf)
+			}
+			continue
+		}
+		assert.Nil(t, err, tc.asOf)
+		assert.Equal(t, tc.exp, ts, tc.asOf)
+	}
+}
+
+func TestHLCString(t *testing.T) {
+	ts := hlc{wall: 1570017600000000000, logical: 12}
+	assert.Equal(t, "1570017600000000000.0000000012", ts.String())
+	parsed, err := parseTimestamp(ts.String())
+	assert.Nil(t, err)
+	assert.Equal(t, ts, parsed)
+}
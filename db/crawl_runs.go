@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// SkipReason describes why the crawler did not add an article to the feed.
+type SkipReason string
+
+// SkipReason values.
+const (
+	// SkipNoExtract is used for articles without an extract.
+	SkipNoExtract SkipReason = "no_extract"
+	// SkipNoImage is used for articles without a usable image.
+	SkipNoImage SkipReason = "no_image"
+	// SkipFetchError is used for articles which could not be retrieved.
+	SkipFetchError SkipReason = "fetch_error"
+	// SkipFiltered is used for pages which are not articles, such as the
+	// main page or special pages.
+	SkipFiltered SkipReason = "filtered"
+	// SkipDisambiguation is used for disambiguation pages.
+	SkipDisambiguation SkipReason = "disambiguation"
+	// SkipMainPage is used for pages which the API reports to be the main
+	// page.
+	SkipMainPage SkipReason = "main_page"
+	// SkipRedirect is used for redirects whose views are counted towards the
+	// article to which they redirect.
+	SkipRedirect SkipReason = "redirect"
+)
+
+// SkipReasons lists every SkipReason.
+var SkipReasons = []SkipReason{
+	SkipNoExtract, SkipNoImage, SkipFetchError, SkipFiltered, SkipDisambiguation,
+	SkipMainPage, SkipRedirect,
+}
+
+// ParseSkipReason parses a SkipReason.
+func ParseSkipReason(s string) (SkipReason, error) {
+	for _, r := range SkipReasons {
+		if string(r) == s {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("unknown skip reason %q", s)
+}
+
+// SkipCount is the number of articles skipped for a reason.
+type SkipCount struct {
+	Reason SkipReason `json:"reason"`
+	Count  int        `json:"count"`
+}
+
+// CrawlRun records the crawl of the top articles of a project for a day.
+type CrawlRun struct {
+	Project  string    `json:"project"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Day is the day whose top articles were crawled.
+	Day time.Time `json:"day"`
+	// Fetched is the number of articles written to the feed.
+	Fetched int `json:"fetched"`
+	// Skipped is the number of articles which were retrieved but left out of
+	// the feed.
+	Skipped int `json:"skipped"`
+	// Failed is the number of articles which could not be retrieved.
+	Failed      int         `json:"failed"`
+	SkipReasons []SkipCount `json:"skip_reasons"`
+	// Error is the error which ended the crawl, if any.
+	Error string `json:"error"`
+}
+
+// AddSkip counts an article skipped for reason.
+func (r *CrawlRun) AddSkip(reason SkipReason) {
+	if reason == SkipFetchError {
+		r.Failed++
+	} else {
+		r.Skipped++
+	}
+	for i := range r.SkipReasons {
+		if r.SkipReasons[i].Reason == reason {
+			r.SkipReasons[i].Count++
+			return
+		}
+	}
+	r.SkipReasons = append(r.SkipReasons, SkipCount{Reason: reason, Count: 1})
+}
+
+// InsertCrawlRun records r in the database.
+func (db *DB) InsertCrawlRun(ctx context.Context, r CrawlRun) error {
+	skipReasons, err := json.Marshal(r.SkipReasons)
+	if err != nil {
+		return err
+	}
+	_, err = db.connPool.ExecEx(ctx, `INSERT
+	INTO
+		crawl_runs
+			(
+				project,
+				started,
+				finished,
+				day,
+				fetched,
+				skipped,
+				failed,
+				skip_reasons,
+				error
+			)
+	VALUES
+		($1, $2, $3, $4::TIMESTAMPTZ::DATE, $5, $6, $7, $8::JSONB, $9)`,
+		nil,
+		r.Project,
+		r.Started,
+		r.Finished,
+		r.Day,
+		r.Fetched,
+		r.Skipped,
+		r.Failed,
+		string(skipReasons),
+		r.Error)
+	return err
+}
+
+const crawlRunsSelection = `SELECT
+		project,
+		started,
+		finished,
+		day::TIMESTAMPTZ,
+		fetched,
+		skipped,
+		failed,
+		COALESCE(skip_reasons::STRING, '[]'),
+		COALESCE(error, '')
+	FROM crawl_runs`
+
+// GetCrawlRuns returns the most recent crawl runs of project, newest first.
+func (db *DB) GetCrawlRuns(ctx context.Context, project string, limit int) ([]CrawlRun, error) {
+	rows, err := db.connPool.QueryEx(ctx, crawlRunsSelection+`
+	WHERE project = $1
+	ORDER BY started DESC
+	LIMIT $2`, nil, project, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanCrawlRuns(rows)
+}
+
+// GetLatestCrawlRuns returns the most recent crawl run of each project.
+func (db *DB) GetLatestCrawlRuns(ctx context.Context) ([]CrawlRun, error) {
+	rows, err := db.connPool.QueryEx(ctx, `SELECT DISTINCT ON (project) * FROM (`+
+		crawlRunsSelection+`)
+	ORDER BY project, started DESC`, nil)
+	if err != nil {
+		return nil, err
+	}
+	return scanCrawlRuns(rows)
+}
+
+func scanCrawlRuns(rows *pgx.Rows) ([]CrawlRun, error) {
+	defer rows.Close()
+	var results []CrawlRun
+	for rows.Next() {
+		var r CrawlRun
+		var skipReasons string
+		if err := rows.Scan(&r.Project, &r.Started, &r.Finished, &r.Day,
+			&r.Fetched, &r.Skipped, &r.Failed, &skipReasons, &r.Error); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(skipReasons), &r.SkipReasons); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
@@ -3,35 +3,21 @@ package db
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx"
 )
 
-// Probably I want to create a table for articles and then a separate table for article views
-
-// I want information about articles on an hourly basis as well as a daily basis.
-
-const (
-	DatabaseName  = "wikifeedia"
-	articlesTable = `CREATE TABLE IF NOT EXISTS articles (
-		project STRING NOT NULL,
-		article STRING NOT NULL,
-		title STRING,
-		thumbnail_url STRING,
-		image_url STRING,
-		abstract STRING,
-		article_url STRING,
-		daily_views INT NOT NULL,
-		retrieved TIMESTAMPTZ NOT NULL,
-		INDEX (project, daily_views DESC),
-		PRIMARY KEY (project, article)
-	);`
-)
+// DatabaseName is the name of the database of the application.
+const DatabaseName = "wikifeedia"
 
 // Article is the data model for a Wikipedia article.
 type Article struct {
+	// Project is the code of the project of the article, e.g. "en" for the
+	// English Wikipedia or "en.wiktionary" for the English Wiktionary.
 	Project      string    `json:"project"`
 	Article      string    `json:"article"`
 	Title        string    `json:"title"`
@@ -41,6 +27,66 @@ type Article struct {
 	ArticleURL   string    `json:"article_url"`
 	DailyViews   int       `json:"daily_views"`
 	Retrieved    time.Time `json:"retrieved"`
+	// ImageWidth and ImageHeight are the dimensions of the image at
+	// ImageURL and ThumbnailWidth and ThumbnailHeight those of the
+	// thumbnail at ThumbnailURL.
+	ImageWidth      int `json:"image_width"`
+	ImageHeight     int `json:"image_height"`
+	ThumbnailWidth  int `json:"thumbnail_width"`
+	ThumbnailHeight int `json:"thumbnail_height"`
+	// ImageLicense is the short name of the license of the image, e.g.
+	// "CC BY-SA 4.0".
+	ImageLicense    string `json:"image_license"`
+	ImageLicenseURL string `json:"image_license_url"`
+	// ImageArtist is the author of the image as plain text.
+	ImageArtist              string `json:"image_artist"`
+	ImageAttributionRequired bool   `json:"image_attribution_required"`
+	// ImageDescriptionURL is the URL of the page describing the image, which
+	// credits it.
+	ImageDescriptionURL string `json:"image_description_url"`
+	// Trending measures how much the daily views of the article exceed its
+	// trailing baseline.
+	Trending float64 `json:"trending"`
+	// ETag identifies the version of the article content which was retrieved.
+	ETag string `json:"etag" graphql:"-"`
+	// Entity describes the subject of the article, if it is known.
+	Entity *Entity `json:"entity"`
+}
+
+// OrderBy determines the order in which articles are returned.
+type OrderBy string
+
+// OrderBy values.
+const (
+	// OrderByTrending orders articles by their trending score.
+	OrderByTrending OrderBy = "TRENDING"
+	// OrderByViews orders articles by their daily views.
+	OrderByViews OrderBy = "VIEWS"
+	// OrderByRecent orders articles by when they were last retrieved.
+	OrderByRecent OrderBy = "RECENT"
+)
+
+// orderByColumns maps each OrderBy to the ORDER BY clause which implements it.
+var orderByColumns = map[OrderBy]string{
+	OrderByTrending: "trending DESC",
+	OrderByViews:    "daily_views DESC",
+	OrderByRecent:   "retrieved DESC",
+}
+
+// Granularity is the time granularity at which article views are queried.
+type Granularity string
+
+// Granularity values.
+const (
+	Hourly Granularity = "HOURLY"
+	Daily  Granularity = "DAILY"
+)
+
+// ArticleViews is the number of views of an article in the hour or day
+// starting at Timestamp.
+type ArticleViews struct {
+	Timestamp time.Time `json:"ts"`
+	Views     int       `json:"views"`
 }
 
 // DB is a wrapper around a pgx.ConnPool that knows about the structure of our application schema.
@@ -48,18 +94,19 @@ type DB struct {
 	connPool *pgx.ConnPool
 	conf     pgx.ConnPoolConfig
 
-	getArticles             *pgx.PreparedStatement
-	getArticlesFollowerRead *pgx.PreparedStatement
+	getArticles             map[OrderBy]*pgx.PreparedStatement
+	getArticlesFollowerRead map[OrderBy]*pgx.PreparedStatement
 }
 
 // MaxConnections controls the maximum number of connections for a DB.
 const MaxConnections = 256
 
-// New creates a new DB.
-func New(pgurl string) (*DB, error) {
+// connect creates a pool of connections to the application database of the
+// cluster at pgurl.
+func connect(pgurl string) (*pgx.ConnPool, pgx.ConnPoolConfig, error) {
 	conf, err := pgx.ParseConnectionString(pgurl)
 	if err != nil {
-		return nil, err
+		return nil, pgx.ConnPoolConfig{}, err
 	}
 	conf.Database = DatabaseName
 	poolConf := pgx.ConnPoolConfig{
@@ -67,89 +114,160 @@ func New(pgurl string) (*DB, error) {
 		MaxConnections: MaxConnections,
 	}
 	connPool, err := pgx.NewConnPool(poolConf)
+	return connPool, poolConf, err
+}
+
+// New creates a new DB. It fails unless the schema of the database has been
+// migrated to LatestVersion.
+func New(pgurl string) (*DB, error) {
+	connPool, poolConf, err := connect(pgurl)
 	if err != nil {
 		return nil, err
 	}
-	db := &DB{
-		conf:     poolConf,
-		connPool: connPool,
-	}
-	if err := setupDatabase(db); err != nil {
+	if err := checkSchemaVersion(context.Background(), connPool); err != nil {
+		connPool.Close()
 		return nil, err
 	}
-	db.getArticles, err = connPool.Prepare("get_articles", getArticlesSQL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to prepare get_articles: %v", err)
+	db := &DB{
+		conf:                    poolConf,
+		connPool:                connPool,
+		getArticles:             make(map[OrderBy]*pgx.PreparedStatement, len(orderByColumns)),
+		getArticlesFollowerRead: make(map[OrderBy]*pgx.PreparedStatement, len(orderByColumns)),
 	}
-	db.getArticlesFollowerRead, err = connPool.Prepare("get_articles_follower_read", getArticlesFollowerReadSQL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to prepare get_articles_follower_read: %v", err)
+	for orderBy := range orderByColumns {
+		name := "get_articles_" + strings.ToLower(string(orderBy))
+		db.getArticles[orderBy], err = connPool.Prepare(name,
+			getArticlesSQL(orderBy, ""))
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare %s: %v", name, err)
+		}
+		name += "_follower_read"
+		db.getArticlesFollowerRead[orderBy], err = connPool.Prepare(name,
+			getArticlesSQL(orderBy, "experimental_follower_read_timestamp()"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare %s: %v", name, err)
+		}
 	}
 	return db, nil
 }
 
-const (
-	getArticlesSelection = `SELECT * FROM (
+// getArticlesSQL returns the query which reads the articles of a project in
+// the specified order, as of the specified expression if non-empty. The
+// expression is included verbatim and must never come from user input.
+// Articles are restricted to the instances of a Wikidata class unless it is
+// empty.
+func getArticlesSQL(orderBy OrderBy, asOf string) string {
+	order := orderByColumns[orderBy]
+	var asOfClause string
+	if asOf != "" {
+		asOfClause = " AS OF SYSTEM TIME " + asOf
+	}
+	return `SELECT * FROM (
 		SELECT
-			  project,
-			  article,
+			  a.project,
+			  a.article,
 			  title,
 			  thumbnail_url,
 			  image_url,
 			  abstract,
 			  article_url,
 			  daily_views,
+			  trending,
+			  image_width,
+			  image_height,
+			  thumbnail_width,
+			  thumbnail_height,
+			  image_license,
+			  image_license_url,
+			  image_artist,
+			  image_attribution_required,
+			  image_description_url,
+			  COALESCE(e.entity::STRING, ''),
 			  cluster_logical_timestamp()::STRING
-		  FROM articles
-		WHERE project = $1
-		ORDER BY daily_views DESC
+		  FROM articles AS a
+	 LEFT JOIN article_entities AS e ON e.project = a.project AND e.article = a.article
+		WHERE a.project = $1 AND ($4 = '' OR $4 = ANY (e.classes))
+		ORDER BY ` + order + `
 		LIMIT ($2 + $3)
-	  )`
-	getArticlesModifiers       = `ORDER BY daily_views DESC OFFSET $3`
-	getArticlesSQL             = getArticlesSelection + getArticlesModifiers
-	getArticlesFollowerReadSQL = getArticlesSelection +
-		` AS OF SYSTEM TIME experimental_follower_read_timestamp()` +
-		getArticlesModifiers
-	getArticlesFollowerReadAsOfSQL = getArticlesSelection +
-		` AS OF SYSTEM TIME '%s'` +
-		getArticlesModifiers
-)
+	  )` + asOfClause + ` ORDER BY ` + order + ` OFFSET $3`
+}
 
-// GetArticles returns the list of articles.
+// GetArticles returns the list of articles. If instanceOf is non-empty, only
+// articles whose subject is an instance of that Wikidata class are returned.
+// A non-empty asOf must be a timestamp as returned by a previous call or an
+// RFC 3339 time within MaxAsOfAge, or else an *InvalidAsOfError is returned.
 func (db *DB) GetArticles(
-	ctx context.Context, project string, offset, limit int, followerRead bool, asOf string,
+	ctx context.Context,
+	project string,
+	offset, limit int,
+	orderBy OrderBy,
+	instanceOf string,
+	followerRead bool,
+	asOf string,
 ) (_ []Article, newAsOf string, _ error) {
-	stmt := db.getArticles.Name
+	if _, ok := orderByColumns[orderBy]; !ok {
+		return nil, "", fmt.Errorf("invalid order %q", orderBy)
+	}
+	if offset < 0 || limit < 0 {
+		return nil, "", fmt.Errorf("invalid offset %d or limit %d", offset, limit)
+	}
+	stmt := db.getArticles[orderBy].Name
 	if followerRead && asOf == "" {
-		stmt = db.getArticlesFollowerRead.Name
+		stmt = db.getArticlesFollowerRead[orderBy].Name
 	} else if followerRead {
-		stmt = fmt.Sprintf(getArticlesFollowerReadAsOfSQL, asOf)
+		ts, err := parseAsOf(asOf, time.Now())
+		if err != nil {
+			return nil, "", err
+		}
+		// AS OF SYSTEM TIME only accepts a constant, so the validated
+		// timestamp is formatted into the query, which leaves no room for
+		// anything other than digits and a decimal point. The query
+		// differs for every timestamp, so it is not worth preparing.
+		stmt = getArticlesSQL(orderBy, ts.String())
 	}
-	rows, err := db.connPool.QueryEx(ctx, stmt, nil, project, limit, offset)
+	rows, err := db.connPool.QueryEx(ctx, stmt, nil, project, limit, offset, instanceOf)
 	if err != nil {
 		return nil, "", err
 	}
+	defer rows.Close()
 	var results []Article
-	var a Article
 	for rows.Next() {
+		var a Article
+		var entity string
 		if err := rows.Scan(&a.Project, &a.Article, &a.Title,
 			&a.ThumbnailURL, &a.ImageURL, &a.Abstract,
-			&a.ArticleURL, &a.DailyViews, &asOf); err != nil {
+			&a.ArticleURL, &a.DailyViews, &a.Trending,
+			&a.ImageWidth, &a.ImageHeight, &a.ThumbnailWidth, &a.ThumbnailHeight,
+			&a.ImageLicense, &a.ImageLicenseURL, &a.ImageArtist,
+			&a.ImageAttributionRequired, &a.ImageDescriptionURL,
+			&entity, &asOf); err != nil {
 			return nil, "", err
 		}
+		if entity != "" {
+			a.Entity = new(Entity)
+			if err := json.Unmarshal([]byte(entity), a.Entity); err != nil {
+				return nil, "", err
+			}
+		}
 		results = append(results, a)
 	}
 	return results, asOf, rows.Err()
 }
 
 // DeleteOldArticles deletes articles which were retrieved before the specified
-// time.
+// time along with their entities.
 func (db *DB) DeleteOldArticles(
 	ctx context.Context, project string, retrievedBefore time.Time,
 ) error {
-	_, err := db.connPool.ExecEx(ctx,
+	if _, err := db.connPool.ExecEx(ctx,
 		`DELETE FROM articles WHERE project = $1 AND retrieved < $2`,
-		nil, project, retrievedBefore)
+		nil, project, retrievedBefore); err != nil {
+		return err
+	}
+	_, err := db.connPool.ExecEx(ctx, `DELETE FROM article_entities
+		WHERE project = $1
+		  AND article NOT IN (SELECT article FROM articles WHERE project = $1)`,
+		nil, project)
 	return err
 }
 
@@ -167,10 +285,21 @@ func (db *DB) UpsertArticle(ctx context.Context, a Article) error {
 				abstract,
 				article_url,
 				daily_views,
-				retrieved
+				retrieved,
+				trending,
+				etag,
+				image_width,
+				image_height,
+				thumbnail_width,
+				thumbnail_height,
+				image_license,
+				image_license_url,
+				image_artist,
+				image_attribution_required,
+				image_description_url
 			)
 	VALUES
-		($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)`,
 		nil,
 		a.Project,
 		a.Article,
@@ -180,15 +309,124 @@ func (db *DB) UpsertArticle(ctx context.Context, a Article) error {
 		a.Abstract,
 		a.ArticleURL,
 		a.DailyViews,
-		a.Retrieved)
+		a.Retrieved,
+		a.Trending,
+		a.ETag,
+		a.ImageWidth,
+		a.ImageHeight,
+		a.ThumbnailWidth,
+		a.ThumbnailHeight,
+		a.ImageLicense,
+		a.ImageLicenseURL,
+		a.ImageArtist,
+		a.ImageAttributionRequired,
+		a.ImageDescriptionURL)
 	return err
 }
 
-func setupDatabase(db *DB) error {
-	if _, err := db.connPool.Exec("CREATE DATABASE IF NOT EXISTS " + DatabaseName); err != nil {
-		return err
+// UpdateArticleViews updates the views, trending score and retrieval time of
+// an existing article without modifying its content. It returns false if the
+// article does not exist.
+func (db *DB) UpdateArticleViews(
+	ctx context.Context, project, article string, dailyViews int, trending float64, retrieved time.Time,
+) (bool, error) {
+	tag, err := db.connPool.ExecEx(ctx, `UPDATE articles
+		SET daily_views = $3, trending = $4, retrieved = $5
+		WHERE project = $1 AND article = $2`,
+		nil, project, article, dailyViews, trending, retrieved)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetArticleETags returns the ETag of the content of each article of project
+// which has one.
+func (db *DB) GetArticleETags(ctx context.Context, project string) (map[string]string, error) {
+	rows, err := db.connPool.QueryEx(ctx,
+		`SELECT article, etag FROM articles WHERE project = $1 AND etag IS NOT NULL`,
+		nil, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	etags := make(map[string]string)
+	for rows.Next() {
+		var article, etag string
+		if err := rows.Scan(&article, &etag); err != nil {
+			return nil, err
+		}
+		etags[article] = etag
+	}
+	return etags, rows.Err()
+}
+
+// UpsertArticleViews upserts the views of an article at the given
+// granularity into the database. Views of each granularity are stored
+// separately.
+func (db *DB) UpsertArticleViews(
+	ctx context.Context, project, article string, granularity Granularity, views []ArticleViews,
+) error {
+	if len(views) == 0 {
+		return nil
+	}
+	var buf strings.Builder
+	buf.WriteString(`UPSERT INTO article_views (project, article, granularity, ts, views) VALUES `)
+	args := make([]interface{}, 0, 3+2*len(views))
+	args = append(args, project, article, string(granularity))
+	for i, v := range views {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(&buf, "($1, $2, $3, $%d, $%d)", len(args)+1, len(args)+2)
+		args = append(args, v.Timestamp, v.Views)
 	}
-	_, err := db.connPool.Exec(articlesTable)
-	// TODO(ajwerner): validate that the table that exists matches what we expect.
+	_, err := db.connPool.ExecEx(ctx, buf.String(), nil, args...)
 	return err
 }
+
+const (
+	getArticleViewsHourlySQL = `SELECT ts, views
+		FROM article_views
+	   WHERE project = $1 AND article = $2 AND granularity = 'HOURLY' AND ts BETWEEN $3 AND $4
+	ORDER BY ts`
+	// getArticleViewsDailySQL prefers the stored daily views of a day to
+	// those aggregated from its hourly views.
+	getArticleViewsDailySQL = `SELECT DISTINCT ON (day) day, views FROM (
+		  SELECT ts AS day, views, 0 AS preference
+			FROM article_views
+		   WHERE project = $1 AND article = $2 AND granularity = 'DAILY' AND ts BETWEEN $3 AND $4
+	   UNION ALL
+		  SELECT date_trunc('day', ts) AS day, sum(views)::INT, 1
+			FROM article_views
+		   WHERE project = $1 AND article = $2 AND granularity = 'HOURLY' AND ts BETWEEN $3 AND $4
+		GROUP BY day
+	)
+	ORDER BY day, preference`
+)
+
+// GetArticleViews returns the views of an article between from and to
+// inclusive. Days without stored daily views are aggregated from their
+// hourly views.
+func (db *DB) GetArticleViews(
+	ctx context.Context, project, article string, granularity Granularity, from, to time.Time,
+) ([]ArticleViews, error) {
+	query := getArticleViewsHourlySQL
+	if granularity == Daily {
+		query = getArticleViewsDailySQL
+	}
+	rows, err := db.connPool.QueryEx(ctx, query, nil, project, article, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []ArticleViews
+	for rows.Next() {
+		var v ArticleViews
+		if err := rows.Scan(&v.Timestamp, &v.Views); err != nil {
+			return nil, err
+		}
+		results = append(results, v)
+	}
+	return results, rows.Err()
+}
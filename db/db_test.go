@@ -66,29 +66,204 @@ func TestDB(t *testing.T) {
 	cockroach, pgUrl, err := runCockroach(t)
 	require.Nil(t, err)
 	defer cockroach.Process.Kill()
+	_, err = New(pgUrl)
+	assert.NotNil(t, err, "the schema has not been migrated")
+	m, err := NewMigrator(pgUrl)
+	require.Nil(t, err)
+	defer m.Close()
+	_, err = m.Up(context.Background(), LatestVersion)
+	require.Nil(t, err)
 	db, err := New(pgUrl)
 	require.Nil(t, err)
+	testStore(t, db)
+}
+
+// testStore exercises the semantics which every Store shares.
+func testStore(t *testing.T, s Store) {
 	articles := []Article{
 		{
 			Project:    "en",
 			Article:    "foo",
 			Title:      "foo",
 			DailyViews: 123,
+			Trending:   2,
 		},
 		{
 			Project:    "en",
 			Article:    "bar",
 			Title:      "bar",
 			DailyViews: 321,
+			Trending:   1,
 		},
 	}
 	ctx := context.Background()
 	for _, a := range articles {
-		assert.Nil(t, db.UpsertArticle(ctx, a))
+		assert.Nil(t, s.UpsertArticle(ctx, a))
 	}
-	got, err := db.GetArticles(ctx, "en", 0, 1000, false /* useFollowerRead */)
+	got, asOf, err := s.GetArticles(ctx, "en", 0, 1000, OrderByViews, "", false /* useFollowerRead */, "")
 	assert.Nil(t, err)
 	assert.Len(t, got, 2)
 	assert.Equal(t, articles[1], got[0])
 	assert.Equal(t, articles[0], got[1])
+	got, _, err = s.GetArticles(ctx, "en", 1, 1, OrderByTrending, "", false, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []Article{articles[1]}, got)
+	_, _, err = s.GetArticles(ctx, "en", -1, 1, OrderByTrending, "", false, "")
+	assert.NotNil(t, err)
+	_, _, err = s.GetArticles(ctx, "en", 0, -1, OrderByTrending, "", false, "")
+	assert.NotNil(t, err)
+
+	// Reads as of the time of the first page are unaffected by later writes.
+	later := Article{Project: "en", Article: "baz", Title: "baz", DailyViews: 999}
+	assert.Nil(t, s.UpsertArticle(ctx, later))
+	got, _, err = s.GetArticles(ctx, "en", 0, 1000, OrderByViews, "", true, asOf)
+	assert.Nil(t, err)
+	assert.Equal(t, articles[1], got[0])
+	assert.Len(t, got, 2)
+	got, _, err = s.GetArticles(ctx, "en", 0, 1000, OrderByViews, "", false, "")
+	assert.Nil(t, err)
+	assert.Equal(t, later, got[0])
+	assert.Len(t, got, 3)
+
+	entity := Entity{Item: "Q1", Classes: []EntityClass{{ID: "Q5", Label: "human"}}}
+	assert.Nil(t, s.UpsertArticleEntity(ctx, "en", "foo", entity))
+	got, _, err = s.GetArticles(ctx, "en", 0, 1000, OrderByViews, "Q5", false, "")
+	assert.Nil(t, err)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "foo", got[0].Article)
+		assert.Equal(t, &entity, got[0].Entity)
+	}
+
+	updated, err := s.UpdateArticleViews(ctx, "en", "foo", 1000, 3, time.Now())
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	updated, err = s.UpdateArticleViews(ctx, "en", "missing", 1000, 3, time.Now())
+	assert.Nil(t, err)
+	assert.False(t, updated)
+	assert.Nil(t, s.DeleteOldArticles(ctx, "en", time.Now().Add(-time.Hour)))
+	got, _, err = s.GetArticles(ctx, "en", 0, 1000, OrderByRecent, "", false, "")
+	assert.Nil(t, err)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "foo", got[0].Article)
+		assert.Equal(t, 1000, got[0].DailyViews)
+	}
+
+	day := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, s.UpsertArticleViews(ctx, "en", "foo", Hourly, []ArticleViews{
+		{Timestamp: day, Views: 1},
+		{Timestamp: day.Add(time.Hour), Views: 2},
+		{Timestamp: day.Add(25 * time.Hour), Views: 4},
+	}))
+	// Daily views do not overwrite hourly views at the same time.
+	assert.Nil(t, s.UpsertArticleViews(ctx, "en", "foo", Daily, []ArticleViews{
+		{Timestamp: day, Views: 10},
+		{Timestamp: day.Add(48 * time.Hour), Views: 20},
+	}))
+	views, err := s.GetArticleViews(ctx, "en", "foo", Daily, day, day.Add(48*time.Hour))
+	assert.Nil(t, err)
+	if assert.Len(t, views, 3) {
+		// Stored daily views are preferred to the sum of hourly views.
+		assert.True(t, views[0].Timestamp.Equal(day))
+		assert.Equal(t, 10, views[0].Views)
+		assert.Equal(t, 4, views[1].Views)
+		assert.Equal(t, 20, views[2].Views)
+	}
+	views, err = s.GetArticleViews(ctx, "en", "foo", Hourly, day, day.Add(48*time.Hour))
+	assert.Nil(t, err)
+	if assert.Len(t, views, 3) {
+		assert.Equal(t, 1, views[0].Views)
+		assert.Equal(t, 2, views[1].Views)
+		assert.Equal(t, 4, views[2].Views)
+	}
+
+	started := time.Date(2019, 10, 2, 1, 0, 0, 0, time.UTC)
+	for i, project := range []string{"en", "en", "fr"} {
+		assert.Nil(t, s.InsertCrawlRun(ctx, CrawlRun{
+			Project:  project,
+			Started:  started.Add(time.Duration(i) * time.Minute),
+			Finished: started.Add(time.Duration(i)*time.Minute + time.Second),
+			Day:      day,
+			Fetched:  i,
+		}))
+	}
+	runs, err := s.GetCrawlRuns(ctx, "en", 10)
+	assert.Nil(t, err)
+	if assert.Len(t, runs, 2) {
+		assert.Equal(t, 1, runs[0].Fetched)
+	}
+	runs, err = s.GetLatestCrawlRuns(ctx)
+	assert.Nil(t, err)
+	if assert.Len(t, runs, 2) {
+		assert.Equal(t, "en", runs[0].Project)
+		assert.Equal(t, 1, runs[0].Fetched)
+		assert.Equal(t, "fr", runs[1].Project)
+	}
+
+	assert.Nil(t, s.UpsertSkippedArticles(ctx, []SkippedArticle{
+		{Project: "en", Day: day, Article: "a", Reason: SkipNoImage, Views: 1, Recorded: started},
+		{Project: "en", Day: day, Article: "b", Reason: SkipNoExtract, Views: 2, Recorded: started},
+	}))
+	skipped, err := s.GetSkippedArticles(ctx, "en", day, "", 10)
+	assert.Nil(t, err)
+	if assert.Len(t, skipped, 2) {
+		assert.Equal(t, "b", skipped[0].Article)
+	}
+	skipped, err = s.GetSkippedArticles(ctx, "en", day, SkipNoImage, 10)
+	assert.Nil(t, err)
+	assert.Len(t, skipped, 1)
+	assert.Nil(t, s.DeleteSkippedArticles(ctx, "en", day, []string{"a"}))
+	skipped, err = s.GetSkippedArticles(ctx, "en", day, "", 10)
+	assert.Nil(t, err)
+	if assert.Len(t, skipped, 1) {
+		assert.Equal(t, "b", skipped[0].Article)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	for i, m := range Migrations {
+		assert.Equal(t, i+1, m.Version, "migrations must be numbered consecutively")
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Up, m.Name)
+		assert.NotEmpty(t, m.Down, m.Name)
+	}
+	if !haveCockroach {
+		t.Skip("Don't have cockroach")
+	}
+	cockroach, pgUrl, err := runCockroach(t)
+	require.Nil(t, err)
+	defer cockroach.Process.Kill()
+	m, err := NewMigrator(pgUrl)
+	require.Nil(t, err)
+	defer m.Close()
+	ctx := context.Background()
+	applied, err := m.Up(ctx, LatestVersion)
+	require.Nil(t, err)
+	assert.Len(t, applied, len(Migrations))
+	reverted, err := m.Down(ctx, 0)
+	require.Nil(t, err)
+	assert.Len(t, reverted, len(Migrations))
+	version, err := m.Version(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 0, version)
+	_, err = m.Up(ctx, 3)
+	require.Nil(t, err)
+	status, err := m.Status(ctx)
+	require.Nil(t, err)
+	assert.False(t, status[2].Applied.IsZero())
+	assert.True(t, status[3].Applied.IsZero())
+}
+
+func TestCrawlRunAddSkip(t *testing.T) {
+	var r CrawlRun
+	r.AddSkip(SkipNoImage)
+	r.AddSkip(SkipFetchError)
+	r.AddSkip(SkipNoImage)
+	r.AddSkip(SkipNoExtract)
+	assert.Equal(t, 3, r.Skipped)
+	assert.Equal(t, 1, r.Failed)
+	assert.Equal(t, []SkipCount{
+		{Reason: SkipNoImage, Count: 2},
+		{Reason: SkipFetchError, Count: 1},
+		{Reason: SkipNoExtract, Count: 1},
+	}, r.SkipReasons)
 }
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Entity describes the subject of an article using facts from Wikidata.
+type Entity struct {
+	// Item is the Wikidata item of the entity, e.g. "Q42".
+	Item        string        `json:"item"`
+	Description string        `json:"description"`
+	Classes     []EntityClass `json:"classes"`
+	Coordinates *Coordinates  `json:"coordinates"`
+	Dates       []EntityDate  `json:"dates"`
+	ImageURL    string        `json:"image_url"`
+}
+
+// EntityClass is a class of which an Entity is an instance, e.g. "Q5"
+// (human).
+type EntityClass struct {
+	ID    string `json:"id"`
+	Label string `json:"label"`
+}
+
+// Coordinates locates an Entity on Earth.
+type Coordinates struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// EntityDate is a date of an Entity, such as its birth.
+type EntityDate struct {
+	Kind string `json:"kind"`
+	// Date is formatted to its precision as YYYY, YYYY-MM or YYYY-MM-DD.
+	Date string `json:"date"`
+	Year int    `json:"year"`
+}
+
+// UpsertArticleEntity upserts the entity which is the subject of an article
+// into the database.
+func (db *DB) UpsertArticleEntity(ctx context.Context, project, article string, e Entity) error {
+	entity, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	classes := make([]string, len(e.Classes))
+	for i, c := range e.Classes {
+		classes[i] = c.ID
+	}
+	_, err = db.connPool.ExecEx(ctx, `UPSERT
+	INTO
+		article_entities (project, article, item, classes, entity)
+	VALUES
+		($1, $2, $3, $4, $5::JSONB)`,
+		nil, project, article, e.Item, classes, string(entity))
+	return err
+}
@@ -0,0 +1,534 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultFollowerReadStaleness is roughly how far in the past CockroachDB
+// serves follower reads with its default cluster settings.
+const DefaultFollowerReadStaleness = 4800 * time.Millisecond
+
+// historyTTL is how long superseded versions of articles and entities are
+// retained for reads as of past times, like the default GC TTL of
+// CockroachDB.
+const historyTTL = 25 * time.Hour
+
+// memRetention is how long a MemStore retains crawl runs and skipped
+// articles. Unlike DB, a MemStore is bounded by the memory of the process.
+const memRetention = 7 * 24 * time.Hour
+
+// MemStore is a Store which keeps everything in memory. It is intended for
+// tests and local development. Like DB, it retains the history of articles
+// and their entities so that they may be read as of a past time. Unlike DB,
+// it discards the views of articles once they have left the feed, and crawl
+// runs and skipped articles after memRetention, except for the latest crawl
+// run of each project.
+type MemStore struct {
+	followerReadStaleness time.Duration
+
+	mu struct {
+		sync.Mutex
+		clock           hlc
+		articles        map[articleKey]history
+		entities        map[articleKey]history
+		views           map[viewsKey]map[time.Time]int
+		crawlRuns       []CrawlRun
+		skippedArticles map[skippedArticleKey]SkippedArticle
+	}
+}
+
+// MemStoreOption configures a MemStore.
+type MemStoreOption func(*MemStore)
+
+// WithFollowerReadStaleness configures how far in the past follower reads
+// without an explicit time are served.
+func WithFollowerReadStaleness(d time.Duration) MemStoreOption {
+	return func(s *MemStore) { s.followerReadStaleness = d }
+}
+
+// NewMemStore creates an empty MemStore.
+func NewMemStore(opts ...MemStoreOption) *MemStore {
+	s := &MemStore{followerReadStaleness: DefaultFollowerReadStaleness}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.mu.articles = make(map[articleKey]history)
+	s.mu.entities = make(map[articleKey]history)
+	s.mu.views = make(map[viewsKey]map[time.Time]int)
+	s.mu.skippedArticles = make(map[skippedArticleKey]SkippedArticle)
+	return s
+}
+
+type articleKey struct {
+	project, article string
+}
+
+type viewsKey struct {
+	articleKey
+	granularity Granularity
+}
+
+type skippedArticleKey struct {
+	project string
+	day     time.Time
+	article string
+}
+
+// now advances the clock of s and returns its new value. It must be called
+// with s.mu held.
+func (s *MemStore) now() hlc {
+	wall := time.Now().UnixNano()
+	if wall > s.mu.clock.wall {
+		s.mu.clock = hlc{wall: wall}
+	} else {
+		s.mu.clock.logical++
+	}
+	return s.mu.clock
+}
+
+// version is a value written at a timestamp. A nil value records a deletion.
+type version struct {
+	ts    hlc
+	value interface{}
+}
+
+// history is the versions of a row in increasing order of timestamp.
+type history []version
+
+// at returns the value of h as of ts.
+func (h history) at(ts hlc) interface{} {
+	for i := len(h) - 1; i >= 0; i-- {
+		if !ts.less(h[i].ts) {
+			return h[i].value
+		}
+	}
+	return nil
+}
+
+// latest returns the current value of h.
+func (h history) latest() interface{} {
+	if len(h) == 0 {
+		return nil
+	}
+	return h[len(h)-1].value
+}
+
+// put appends value at ts and discards versions which can no longer be read
+// because they were superseded before historyTTL.
+func (h history) put(ts hlc, value interface{}) history {
+	h = append(h, version{ts: ts, value: value})
+	gc := hlc{wall: ts.wall - int64(historyTTL)}
+	i := 0
+	for i+1 < len(h) && h[i+1].ts.less(gc) {
+		i++
+	}
+	return h[i:]
+}
+
+// put writes value for key to table. It must be called with s.mu held.
+func (s *MemStore) put(table map[articleKey]history, key articleKey, ts hlc, value interface{}) {
+	table[key] = table[key].put(ts, value)
+}
+
+// GetArticles implements Store.
+func (s *MemStore) GetArticles(
+	ctx context.Context,
+	project string,
+	offset, limit int,
+	orderBy OrderBy,
+	instanceOf string,
+	followerRead bool,
+	asOf string,
+) (_ []Article, newAsOf string, _ error) {
+	if _, ok := orderByColumns[orderBy]; !ok {
+		return nil, "", fmt.Errorf("invalid order %q", orderBy)
+	}
+	if offset < 0 || limit < 0 {
+		return nil, "", fmt.Errorf("invalid offset %d or limit %d", offset, limit)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := s.now()
+	if followerRead && asOf == "" {
+		ts = hlc{wall: ts.wall - int64(s.followerReadStaleness)}
+	} else if followerRead {
+		readTS, err := parseAsOf(asOf, time.Unix(0, ts.wall))
+		if err != nil {
+			return nil, "", err
+		}
+		if ts.less(readTS) {
+			return nil, "", &InvalidAsOfError{AsOf: asOf, Reason: "in the future"}
+		}
+		ts = readTS
+	}
+	var results []Article
+	for key, h := range s.mu.articles {
+		if key.project != project {
+			continue
+		}
+		v := h.at(ts)
+		if v == nil {
+			continue
+		}
+		a := v.(Article)
+		// Like DB, the retrieval time and ETag are not read.
+		a.Retrieved = time.Time{}
+		a.ETag = ""
+		if e, ok := s.mu.entities[key].at(ts).(Entity); ok {
+			e = copyEntity(e)
+			a.Entity = &e
+		}
+		if instanceOf != "" && !isInstanceOf(a.Entity, instanceOf) {
+			continue
+		}
+		results = append(results, a)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := &results[i], &results[j]
+		switch orderBy {
+		case OrderByTrending:
+			if a.Trending != b.Trending {
+				return a.Trending > b.Trending
+			}
+		case OrderByViews:
+			if a.DailyViews != b.DailyViews {
+				return a.DailyViews > b.DailyViews
+			}
+		case OrderByRecent:
+			ra, rb := s.retrieved(a, ts), s.retrieved(b, ts)
+			if !ra.Equal(rb) {
+				return ra.After(rb)
+			}
+		}
+		return a.Article < b.Article
+	})
+	if offset > len(results) {
+		offset = len(results)
+	}
+	results = results[offset:]
+	if limit < len(results) {
+		results = results[:limit]
+	}
+	return results, ts.String(), nil
+}
+
+// retrieved returns the retrieval time of a as of ts. It must be called
+// with s.mu held.
+func (s *MemStore) retrieved(a *Article, ts hlc) time.Time {
+	stored, _ := s.mu.articles[articleKey{a.Project, a.Article}].at(ts).(Article)
+	return stored.Retrieved
+}
+
+func isInstanceOf(e *Entity, class string) bool {
+	if e == nil {
+		return false
+	}
+	for _, c := range e.Classes {
+		if c.ID == class {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteOldArticles implements Store.
+func (s *MemStore) DeleteOldArticles(
+	ctx context.Context, project string, retrievedBefore time.Time,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := s.now()
+	for key, h := range s.mu.articles {
+		if key.project != project {
+			continue
+		}
+		if a, ok := h.latest().(Article); ok && a.Retrieved.Before(retrievedBefore) {
+			s.put(s.mu.articles, key, ts, nil)
+		}
+	}
+	for key, h := range s.mu.entities {
+		if key.project != project || h.latest() == nil {
+			continue
+		}
+		if s.mu.articles[key].latest() == nil {
+			s.put(s.mu.entities, key, ts, nil)
+		}
+	}
+	s.collect(ts)
+	return nil
+}
+
+// collect discards what can no longer be read as of ts: rows whose latest
+// version is a deletion older than historyTTL along with their views, and
+// crawl runs and skipped articles older than memRetention. It must be called
+// with s.mu held.
+func (s *MemStore) collect(ts hlc) {
+	gc := hlc{wall: ts.wall - int64(historyTTL)}
+	for _, table := range []map[articleKey]history{s.mu.articles, s.mu.entities} {
+		for key, h := range table {
+			if last := h[len(h)-1]; last.value == nil && last.ts.less(gc) {
+				delete(table, key)
+			}
+		}
+	}
+	for key := range s.mu.views {
+		if _, ok := s.mu.articles[key.articleKey]; !ok {
+			delete(s.mu.views, key)
+		}
+	}
+	cutoff := time.Unix(0, ts.wall).Add(-memRetention)
+	latest := make(map[string]time.Time)
+	for _, r := range s.mu.crawlRuns {
+		if r.Started.After(latest[r.Project]) {
+			latest[r.Project] = r.Started
+		}
+	}
+	runs := s.mu.crawlRuns[:0]
+	for _, r := range s.mu.crawlRuns {
+		if !r.Started.Before(cutoff) || r.Started.Equal(latest[r.Project]) {
+			runs = append(runs, r)
+		}
+	}
+	s.mu.crawlRuns = runs
+	for key, a := range s.mu.skippedArticles {
+		if a.Recorded.Before(cutoff) {
+			delete(s.mu.skippedArticles, key)
+		}
+	}
+}
+
+// UpsertArticle implements Store.
+func (s *MemStore) UpsertArticle(ctx context.Context, a Article) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The entity is stored separately, as in DB.
+	a.Entity = nil
+	s.put(s.mu.articles, articleKey{a.Project, a.Article}, s.now(), a)
+	return nil
+}
+
+// UpdateArticleViews implements Store.
+func (s *MemStore) UpdateArticleViews(
+	ctx context.Context, project, article string, dailyViews int, trending float64, retrieved time.Time,
+) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := articleKey{project, article}
+	a, ok := s.mu.articles[key].latest().(Article)
+	if !ok {
+		return false, nil
+	}
+	a.DailyViews, a.Trending, a.Retrieved = dailyViews, trending, retrieved
+	s.put(s.mu.articles, key, s.now(), a)
+	return true, nil
+}
+
+// GetArticleETags implements Store.
+func (s *MemStore) GetArticleETags(ctx context.Context, project string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	etags := make(map[string]string)
+	for key, h := range s.mu.articles {
+		if a, ok := h.latest().(Article); ok && key.project == project && a.ETag != "" {
+			etags[key.article] = a.ETag
+		}
+	}
+	return etags, nil
+}
+
+// UpsertArticleEntity implements Store.
+func (s *MemStore) UpsertArticleEntity(ctx context.Context, project, article string, e Entity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(s.mu.entities, articleKey{project, article}, s.now(), copyEntity(e))
+	return nil
+}
+
+// copyEntity returns a deep copy of e so that the stored entity does not
+// share memory with its callers.
+func copyEntity(e Entity) Entity {
+	e.Classes = append([]EntityClass(nil), e.Classes...)
+	e.Dates = append([]EntityDate(nil), e.Dates...)
+	if e.Coordinates != nil {
+		c := *e.Coordinates
+		e.Coordinates = &c
+	}
+	return e
+}
+
+// UpsertArticleViews implements Store.
+func (s *MemStore) UpsertArticleViews(
+	ctx context.Context, project, article string, granularity Granularity, views []ArticleViews,
+) error {
+	if len(views) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := viewsKey{articleKey{project, article}, granularity}
+	series, ok := s.mu.views[key]
+	if !ok {
+		series = make(map[time.Time]int, len(views))
+		s.mu.views[key] = series
+	}
+	for _, v := range views {
+		series[v.Timestamp.UTC()] = v.Views
+	}
+	return nil
+}
+
+// GetArticleViews implements Store.
+func (s *MemStore) GetArticleViews(
+	ctx context.Context, project, article string, granularity Granularity, from, to time.Time,
+) ([]ArticleViews, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := articleKey{project, article}
+	byTS := make(map[time.Time]int)
+	for ts, views := range s.mu.views[viewsKey{key, Hourly}] {
+		if ts.Before(from) || ts.After(to) {
+			continue
+		}
+		if granularity == Daily {
+			ts = truncateDay(ts)
+		}
+		byTS[ts] += views
+	}
+	if granularity == Daily {
+		// Stored daily views are preferred to those aggregated from hourly
+		// views.
+		for ts, views := range s.mu.views[viewsKey{key, Daily}] {
+			if !ts.Before(from) && !ts.After(to) {
+				byTS[ts] = views
+			}
+		}
+	}
+	results := make([]ArticleViews, 0, len(byTS))
+	for ts, views := range byTS {
+		results = append(results, ArticleViews{Timestamp: ts, Views: views})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Timestamp.Before(results[j].Timestamp)
+	})
+	if len(results) == 0 {
+		return nil, nil
+	}
+	return results, nil
+}
+
+// truncateDay returns the start of the day of t in UTC, as DATE columns are
+// stored.
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// InsertCrawlRun implements Store.
+func (s *MemStore) InsertCrawlRun(ctx context.Context, r CrawlRun) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.mu.crawlRuns {
+		if existing.Project == r.Project && existing.Started.Equal(r.Started) {
+			return fmt.Errorf("crawl run of %s started at %v already exists", r.Project, r.Started)
+		}
+	}
+	r.Day = truncateDay(r.Day)
+	r.SkipReasons = append([]SkipCount(nil), r.SkipReasons...)
+	s.mu.crawlRuns = append(s.mu.crawlRuns, r)
+	return nil
+}
+
+// sortedCrawlRuns returns the crawl runs in order of project and then newest
+// first. It must be called with s.mu held.
+func (s *MemStore) sortedCrawlRuns() []CrawlRun {
+	runs := append([]CrawlRun(nil), s.mu.crawlRuns...)
+	sort.Slice(runs, func(i, j int) bool {
+		if runs[i].Project != runs[j].Project {
+			return runs[i].Project < runs[j].Project
+		}
+		return runs[i].Started.After(runs[j].Started)
+	})
+	return runs
+}
+
+// GetCrawlRuns implements Store.
+func (s *MemStore) GetCrawlRuns(ctx context.Context, project string, limit int) ([]CrawlRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []CrawlRun
+	for _, r := range s.sortedCrawlRuns() {
+		if len(results) == limit {
+			break
+		}
+		if r.Project == project {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// GetLatestCrawlRuns implements Store.
+func (s *MemStore) GetLatestCrawlRuns(ctx context.Context) ([]CrawlRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var results []CrawlRun
+	for _, r := range s.sortedCrawlRuns() {
+		if len(results) == 0 || results[len(results)-1].Project != r.Project {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// UpsertSkippedArticles implements Store.
+func (s *MemStore) UpsertSkippedArticles(ctx context.Context, skipped []SkippedArticle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range skipped {
+		a.Day = truncateDay(a.Day)
+		s.mu.skippedArticles[skippedArticleKey{a.Project, a.Day, a.Article}] = a
+	}
+	return nil
+}
+
+// DeleteSkippedArticles implements Store.
+func (s *MemStore) DeleteSkippedArticles(
+	ctx context.Context, project string, day time.Time, articles []string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	day = truncateDay(day)
+	for _, article := range articles {
+		delete(s.mu.skippedArticles, skippedArticleKey{project, day, article})
+	}
+	return nil
+}
+
+// GetSkippedArticles implements Store.
+func (s *MemStore) GetSkippedArticles(
+	ctx context.Context, project string, day time.Time, reason SkipReason, limit int,
+) ([]SkippedArticle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	day = truncateDay(day)
+	var results []SkippedArticle
+	for key, a := range s.mu.skippedArticles {
+		if key.project == project && key.day.Equal(day) && (reason == "" || a.Reason == reason) {
+			results = append(results, a)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Views != results[j].Views {
+			return results[i].Views > results[j].Views
+		}
+		return results[i].Article < results[j].Article
+	})
+	if limit < len(results) {
+		results = results[:limit]
+	}
+	return results, nil
+}
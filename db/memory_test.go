@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStore(t *testing.T) {
+	testStore(t, NewMemStore())
+}
+
+func TestMemStoreAsOf(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStore(WithFollowerReadStaleness(time.Hour))
+	a := Article{Project: "en", Article: "foo", Retrieved: time.Now()}
+	require.Nil(t, s.UpsertArticle(ctx, a))
+
+	// Follower reads without a time are stale.
+	got, _, err := s.GetArticles(ctx, "en", 0, 10, OrderByViews, "", true, "")
+	require.Nil(t, err)
+	assert.Len(t, got, 0)
+
+	_, asOf, err := s.GetArticles(ctx, "en", 0, 10, OrderByViews, "", false, "")
+	require.Nil(t, err)
+	require.Nil(t, s.DeleteOldArticles(ctx, "en", time.Now().Add(time.Hour)))
+	got, _, err = s.GetArticles(ctx, "en", 0, 10, OrderByViews, "", false, "")
+	require.Nil(t, err)
+	assert.Len(t, got, 0)
+	got, newAsOf, err := s.GetArticles(ctx, "en", 0, 10, OrderByViews, "", true, asOf)
+	require.Nil(t, err)
+	assert.Len(t, got, 1)
+	assert.Equal(t, asOf, newAsOf)
+
+	_, _, err = s.GetArticles(ctx, "en", 0, 10, OrderByViews, "", true, "not a time")
+	assert.IsType(t, &InvalidAsOfError{}, err)
+	future := hlc{wall: time.Now().Add(time.Hour).UnixNano()}
+	_, _, err = s.GetArticles(ctx, "en", 0, 10, OrderByViews, "", true, future.String())
+	assert.IsType(t, &InvalidAsOfError{}, err)
+}
+
+func TestHistory(t *testing.T) {
+	var h history
+	ts := func(d time.Duration) hlc { return hlc{wall: int64(d)} }
+	h = h.put(ts(time.Hour), "a")
+	h = h.put(ts(2*time.Hour), "b")
+	assert.Nil(t, h.at(ts(0)))
+	assert.Equal(t, "a", h.at(ts(time.Hour)))
+	assert.Equal(t, "b", h.at(ts(3*time.Hour)))
+	// Versions superseded before the TTL are discarded but the version which
+	// was current at the start of the TTL is retained.
+	h = h.put(ts(historyTTL+3*time.Hour), nil)
+	assert.Len(t, h, 2)
+	assert.Equal(t, "b", h.at(ts(3*time.Hour)))
+	assert.Nil(t, h.latest())
+}
+
+func TestMemStoreCollect(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStore()
+	now := time.Now()
+	for _, article := range []string{"foo", "bar"} {
+		require.Nil(t, s.UpsertArticle(ctx, Article{Project: "en", Article: article, Retrieved: now}))
+		require.Nil(t, s.UpsertArticleEntity(ctx, "en", article, Entity{Item: "Q1"}))
+		require.Nil(t, s.UpsertArticleViews(ctx, "en", article, Daily,
+			[]ArticleViews{{Timestamp: truncateDay(now), Views: 1}}))
+	}
+	old := now.Add(-memRetention - time.Hour)
+	for _, r := range []CrawlRun{
+		{Project: "en", Started: old},
+		{Project: "en", Started: now},
+		{Project: "fr", Started: old},
+	} {
+		require.Nil(t, s.InsertCrawlRun(ctx, r))
+	}
+	require.Nil(t, s.UpsertSkippedArticles(ctx, []SkippedArticle{
+		{Project: "en", Day: old, Article: "old", Recorded: old},
+		{Project: "en", Day: now, Article: "new", Recorded: now},
+	}))
+	require.Nil(t, s.UpsertArticle(ctx, Article{Project: "en", Article: "bar", Retrieved: now.Add(time.Hour)}))
+	require.Nil(t, s.DeleteOldArticles(ctx, "en", now.Add(time.Minute)))
+
+	// Deleted rows are retained until they can no longer be read.
+	s.mu.Lock()
+	assert.Len(t, s.mu.articles, 2)
+	assert.Len(t, s.mu.entities, 2)
+	assert.Len(t, s.mu.views, 2)
+	s.collect(hlc{wall: now.Add(historyTTL + time.Hour).UnixNano()})
+	assert.Len(t, s.mu.articles, 1)
+	assert.Len(t, s.mu.entities, 1)
+	assert.Len(t, s.mu.views, 1)
+	s.mu.Unlock()
+
+	// The latest run of each project is retained however old it is.
+	runs, err := s.GetLatestCrawlRuns(ctx)
+	require.Nil(t, err)
+	assert.Len(t, runs, 2)
+	runs, err = s.GetCrawlRuns(ctx, "en", 10)
+	require.Nil(t, err)
+	assert.Len(t, runs, 1)
+	skipped, err := s.GetSkippedArticles(ctx, "en", old, "", 10)
+	require.Nil(t, err)
+	assert.Len(t, skipped, 0)
+	skipped, err = s.GetSkippedArticles(ctx, "en", now, "", 10)
+	require.Nil(t, err)
+	assert.Len(t, skipped, 1)
+}
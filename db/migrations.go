@@ -0,0 +1,313 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
+)
+
+// Migration is a versioned change to the schema of the database.
+type Migration struct {
+	Version int
+	Name    string
+	// Up applies the migration and Down reverts it. Their statements are
+	// executed in order and are idempotent so that a migration which failed
+	// part way through can be retried, and so that databases which were
+	// created before migrations were versioned can be brought up to date.
+	Up   []string
+	Down []string
+}
+
+// Migrations are the migrations of the schema in order of version.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create articles",
+		Up: []string{`CREATE TABLE IF NOT EXISTS articles (
+			project STRING NOT NULL,
+			article STRING NOT NULL,
+			title STRING,
+			thumbnail_url STRING,
+			image_url STRING,
+			abstract STRING,
+			article_url STRING,
+			daily_views INT NOT NULL,
+			retrieved TIMESTAMPTZ NOT NULL,
+			INDEX (project, daily_views DESC),
+			PRIMARY KEY (project, article)
+		)`},
+		Down: []string{`DROP TABLE IF EXISTS articles`},
+	},
+	{
+		Version: 2,
+		Name:    "create article_views",
+		Up: []string{`CREATE TABLE IF NOT EXISTS article_views (
+			project STRING NOT NULL,
+			article STRING NOT NULL,
+			granularity STRING NOT NULL,
+			ts TIMESTAMPTZ NOT NULL,
+			views INT NOT NULL,
+			PRIMARY KEY (project, article, granularity, ts)
+		)`},
+		Down: []string{`DROP TABLE IF EXISTS article_views`},
+	},
+	{
+		Version: 3,
+		Name:    "add trending score and order indexes to articles",
+		Up: []string{
+			`ALTER TABLE articles ADD COLUMN IF NOT EXISTS trending FLOAT8 NOT NULL DEFAULT 0`,
+			`CREATE INDEX IF NOT EXISTS articles_project_trending_idx
+				ON articles (project, trending DESC)`,
+			`CREATE INDEX IF NOT EXISTS articles_project_retrieved_idx
+				ON articles (project, retrieved DESC)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS articles@articles_project_retrieved_idx`,
+			`DROP INDEX IF EXISTS articles@articles_project_trending_idx`,
+			`ALTER TABLE articles DROP COLUMN IF EXISTS trending`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create crawl_runs",
+		Up: []string{`CREATE TABLE IF NOT EXISTS crawl_runs (
+			project STRING NOT NULL,
+			started TIMESTAMPTZ NOT NULL,
+			finished TIMESTAMPTZ NOT NULL,
+			day DATE NOT NULL,
+			fetched INT NOT NULL,
+			skipped INT NOT NULL,
+			failed INT NOT NULL,
+			skip_reasons JSONB,
+			error STRING,
+			PRIMARY KEY (project, started DESC)
+		)`},
+		Down: []string{`DROP TABLE IF EXISTS crawl_runs`},
+	},
+	{
+		Version: 5,
+		Name:    "create skipped_articles",
+		Up: []string{`CREATE TABLE IF NOT EXISTS skipped_articles (
+			project STRING NOT NULL,
+			day DATE NOT NULL,
+			article STRING NOT NULL,
+			reason STRING NOT NULL,
+			detail STRING,
+			views INT NOT NULL,
+			recorded TIMESTAMPTZ NOT NULL,
+			INDEX (project, day, reason),
+			PRIMARY KEY (project, day, article)
+		)`},
+		Down: []string{`DROP TABLE IF EXISTS skipped_articles`},
+	},
+	{
+		Version: 6,
+		Name:    "add etag to articles",
+		Up:      []string{`ALTER TABLE articles ADD COLUMN IF NOT EXISTS etag STRING`},
+		Down:    []string{`ALTER TABLE articles DROP COLUMN IF EXISTS etag`},
+	},
+	{
+		Version: 7,
+		Name:    "create article_entities",
+		Up: []string{`CREATE TABLE IF NOT EXISTS article_entities (
+			project STRING NOT NULL,
+			article STRING NOT NULL,
+			item STRING NOT NULL,
+			classes STRING[] NOT NULL,
+			entity JSONB NOT NULL,
+			PRIMARY KEY (project, article)
+		)`},
+		Down: []string{`DROP TABLE IF EXISTS article_entities`},
+	},
+	{
+		Version: 8,
+		Name:    "add image metadata to articles",
+		Up: []string{`ALTER TABLE articles
+			ADD COLUMN IF NOT EXISTS image_width INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS image_height INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS thumbnail_width INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS thumbnail_height INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS image_license STRING NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS image_license_url STRING NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS image_artist STRING NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS image_attribution_required BOOL NOT NULL DEFAULT false,
+			ADD COLUMN IF NOT EXISTS image_description_url STRING NOT NULL DEFAULT ''`},
+		Down: []string{`ALTER TABLE articles
+			DROP COLUMN IF EXISTS image_width,
+			DROP COLUMN IF EXISTS image_height,
+			DROP COLUMN IF EXISTS thumbnail_width,
+			DROP COLUMN IF EXISTS thumbnail_height,
+			DROP COLUMN IF EXISTS image_license,
+			DROP COLUMN IF EXISTS image_license_url,
+			DROP COLUMN IF EXISTS image_artist,
+			DROP COLUMN IF EXISTS image_attribution_required,
+			DROP COLUMN IF EXISTS image_description_url`},
+	},
+}
+
+// LatestVersion is the version of the schema which this package expects.
+var LatestVersion = Migrations[len(Migrations)-1].Version
+
+const schemaMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name STRING NOT NULL,
+		applied TIMESTAMPTZ NOT NULL
+	)`
+
+// MigrationStatus describes whether a Migration has been applied.
+type MigrationStatus struct {
+	Migration
+	// Applied is when the migration was applied, or zero if it is pending.
+	Applied time.Time
+}
+
+// Migrator applies and reverts Migrations. Migrators may apply migrations
+// concurrently, e.g. from several instances of the server which start at
+// once, since migrations are idempotent; one which fails because of a
+// concurrent schema change may simply be retried.
+type Migrator struct {
+	connPool *pgx.ConnPool
+}
+
+// NewMigrator creates a Migrator for the database at pgurl, creating the
+// database if it does not exist.
+func NewMigrator(pgurl string) (*Migrator, error) {
+	connPool, _, err := connect(pgurl)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{
+		"CREATE DATABASE IF NOT EXISTS " + DatabaseName,
+		schemaMigrationsTable,
+	} {
+		if _, err := connPool.Exec(stmt); err != nil {
+			connPool.Close()
+			return nil, err
+		}
+	}
+	return &Migrator{connPool: connPool}, nil
+}
+
+// Close closes the connections of m.
+func (m *Migrator) Close() {
+	m.connPool.Close()
+}
+
+// Version returns the version of the latest migration which was applied, or
+// zero if none was.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	return schemaVersion(ctx, m.connPool)
+}
+
+func schemaVersion(ctx context.Context, connPool *pgx.ConnPool) (int, error) {
+	var version int
+	err := connPool.QueryRowEx(ctx,
+		`SELECT COALESCE(max(version), 0) FROM schema_migrations`, nil).Scan(&version)
+	return version, err
+}
+
+// Status returns the status of every migration in order of version.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	rows, err := m.connPool.QueryEx(ctx, `SELECT version, applied FROM schema_migrations`, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var ts time.Time
+		if err := rows.Scan(&version, &ts); err != nil {
+			return nil, err
+		}
+		applied[version] = ts
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, len(Migrations))
+	for i, mig := range Migrations {
+		status[i] = MigrationStatus{Migration: mig, Applied: applied[mig.Version]}
+	}
+	return status, nil
+}
+
+// Up applies the migrations after the current version up to and including
+// version to, returning those which were applied.
+func (m *Migrator) Up(ctx context.Context, to int) ([]Migration, error) {
+	from, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, mig := range Migrations {
+		if mig.Version <= from || mig.Version > to {
+			continue
+		}
+		if err := m.exec(ctx, mig, mig.Up); err != nil {
+			return applied, err
+		}
+		if _, err := m.connPool.ExecEx(ctx,
+			`UPSERT INTO schema_migrations (version, name, applied) VALUES ($1, $2, now())`,
+			nil, mig.Version, mig.Name); err != nil {
+			return applied, err
+		}
+		applied = append(applied, mig)
+	}
+	return applied, nil
+}
+
+// Down reverts the migrations after version to up to and including the
+// current version, returning those which were reverted.
+func (m *Migrator) Down(ctx context.Context, to int) ([]Migration, error) {
+	from, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for i := len(Migrations) - 1; i >= 0; i-- {
+		mig := Migrations[i]
+		if mig.Version > from || mig.Version <= to {
+			continue
+		}
+		if err := m.exec(ctx, mig, mig.Down); err != nil {
+			return reverted, err
+		}
+		if _, err := m.connPool.ExecEx(ctx,
+			`DELETE FROM schema_migrations WHERE version = $1`, nil, mig.Version); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, mig)
+	}
+	return reverted, nil
+}
+
+func (m *Migrator) exec(ctx context.Context, mig Migration, stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := m.connPool.ExecEx(ctx, stmt, nil); err != nil {
+			return errors.Wrapf(err, "migration %d (%s)", mig.Version, mig.Name)
+		}
+	}
+	return nil
+}
+
+// checkSchemaVersion returns an error unless the schema of the database is at
+// LatestVersion.
+func checkSchemaVersion(ctx context.Context, connPool *pgx.ConnPool) error {
+	version, err := schemaVersion(ctx, connPool)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the schema version, run `wikifeedia migrate up`")
+	}
+	switch {
+	case version < LatestVersion:
+		return fmt.Errorf("schema version %d is older than %d, run `wikifeedia migrate up`",
+			version, LatestVersion)
+	case version > LatestVersion:
+		return fmt.Errorf("schema version %d is newer than %d, which this binary supports",
+			version, LatestVersion)
+	}
+	return nil
+}
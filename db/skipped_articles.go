@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SkippedArticle records an article among the top articles of a day which
+// the crawler left out of the feed.
+type SkippedArticle struct {
+	Project string     `json:"project"`
+	Day     time.Time  `json:"day"`
+	Article string     `json:"article"`
+	Reason  SkipReason `json:"reason"`
+	// Detail optionally elaborates on the reason, e.g. with an error.
+	Detail   string    `json:"detail"`
+	Views    int       `json:"views"`
+	Recorded time.Time `json:"recorded"`
+}
+
+// skippedArticlesBatchSize bounds the number of rows written per statement.
+const skippedArticlesBatchSize = 100
+
+// UpsertSkippedArticles upserts skipped into the database.
+func (db *DB) UpsertSkippedArticles(ctx context.Context, skipped []SkippedArticle) error {
+	for len(skipped) > 0 {
+		batch := skipped
+		if len(batch) > skippedArticlesBatchSize {
+			batch = batch[:skippedArticlesBatchSize]
+		}
+		skipped = skipped[len(batch):]
+		var buf strings.Builder
+		buf.WriteString(`UPSERT INTO skipped_articles
+			(project, day, article, reason, detail, views, recorded) VALUES `)
+		const numCols = 7
+		args := make([]interface{}, 0, numCols*len(batch))
+		for i, s := range batch {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			n := len(args)
+			fmt.Fprintf(&buf, "($%d, $%d::TIMESTAMPTZ::DATE, $%d, $%d, $%d, $%d, $%d)",
+				n+1, n+2, n+3, n+4, n+5, n+6, n+7)
+			args = append(args, s.Project, s.Day, s.Article, string(s.Reason),
+				s.Detail, s.Views, s.Recorded)
+		}
+		if _, err := db.connPool.ExecEx(ctx, buf.String(), nil, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSkippedArticles deletes the records of articles of project which were
+// skipped on day, such as when a later crawl of the day adds them to the feed.
+func (db *DB) DeleteSkippedArticles(
+	ctx context.Context, project string, day time.Time, articles []string,
+) error {
+	if len(articles) == 0 {
+		return nil
+	}
+	_, err := db.connPool.ExecEx(ctx, `DELETE FROM skipped_articles
+	WHERE project = $1
+		AND day = $2::TIMESTAMPTZ::DATE
+		AND article = ANY ($3)`, nil, project, day, articles)
+	return err
+}
+
+// GetSkippedArticles returns the articles of project which were skipped on
+// day in descending order of views. If reason is non-empty, only articles
+// skipped for that reason are returned.
+func (db *DB) GetSkippedArticles(
+	ctx context.Context, project string, day time.Time, reason SkipReason, limit int,
+) ([]SkippedArticle, error) {
+	rows, err := db.connPool.QueryEx(ctx, `SELECT
+		project, day::TIMESTAMPTZ, article, reason, COALESCE(detail, ''), views, recorded
+	FROM skipped_articles
+	WHERE project = $1
+		AND day = $2::TIMESTAMPTZ::DATE
+		AND ($3 = '' OR reason = $3)
+	ORDER BY views DESC
+	LIMIT $4`, nil, project, day, string(reason), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var results []SkippedArticle
+	for rows.Next() {
+		var s SkippedArticle
+		var reason string
+		if err := rows.Scan(&s.Project, &s.Day, &s.Article, &reason,
+			&s.Detail, &s.Views, &s.Recorded); err != nil {
+			return nil, err
+		}
+		s.Reason = SkipReason(reason)
+		results = append(results, s)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Store is the storage of the application. DB implements it on CockroachDB and
+// MemStore in memory.
+type Store interface {
+	// GetArticles returns the articles of project in the specified order. If
+	// instanceOf is non-empty, only articles whose subject is an instance of
+	// that Wikidata class are returned. Follower reads are served as of asOf
+	// if it is non-empty, or as of a recent time otherwise. The returned
+	// newAsOf is the time as of which the articles were read and may be
+	// passed as asOf to read further pages consistently. An asOf which is
+	// malformed or out of range is rejected with an *InvalidAsOfError. A
+	// negative offset or limit is rejected too.
+	GetArticles(
+		ctx context.Context,
+		project string,
+		offset, limit int,
+		orderBy OrderBy,
+		instanceOf string,
+		followerRead bool,
+		asOf string,
+	) (_ []Article, newAsOf string, _ error)
+	// DeleteOldArticles deletes articles which were retrieved before the
+	// specified time along with their entities.
+	DeleteOldArticles(ctx context.Context, project string, retrievedBefore time.Time) error
+	// UpsertArticle upserts a.
+	UpsertArticle(ctx context.Context, a Article) error
+	// UpdateArticleViews updates the views, trending score and retrieval time
+	// of an existing article without modifying its content. It returns false
+	// if the article does not exist.
+	UpdateArticleViews(
+		ctx context.Context, project, article string, dailyViews int, trending float64, retrieved time.Time,
+	) (bool, error)
+	// GetArticleETags returns the ETag of the content of each article of
+	// project which has one.
+	GetArticleETags(ctx context.Context, project string) (map[string]string, error)
+	// UpsertArticleEntity upserts the entity which is the subject of an
+	// article.
+	UpsertArticleEntity(ctx context.Context, project, article string, e Entity) error
+	// UpsertArticleViews upserts the views of an article at the given
+	// granularity. Views of each granularity are stored separately.
+	UpsertArticleViews(
+		ctx context.Context, project, article string, granularity Granularity, views []ArticleViews,
+	) error
+	// GetArticleViews returns the views of an article between from and to
+	// inclusive. Days without stored daily views are aggregated from their
+	// hourly views.
+	GetArticleViews(
+		ctx context.Context, project, article string, granularity Granularity, from, to time.Time,
+	) ([]ArticleViews, error)
+	// InsertCrawlRun records r.
+	InsertCrawlRun(ctx context.Context, r CrawlRun) error
+	// GetCrawlRuns returns the most recent crawl runs of project, newest
+	// first.
+	GetCrawlRuns(ctx context.Context, project string, limit int) ([]CrawlRun, error)
+	// GetLatestCrawlRuns returns the most recent crawl run of each project
+	// in order of project.
+	GetLatestCrawlRuns(ctx context.Context) ([]CrawlRun, error)
+	// UpsertSkippedArticles upserts skipped.
+	UpsertSkippedArticles(ctx context.Context, skipped []SkippedArticle) error
+	// DeleteSkippedArticles deletes the records of articles of project
+	// which were skipped on day.
+	DeleteSkippedArticles(ctx context.Context, project string, day time.Time, articles []string) error
+	// GetSkippedArticles returns the articles of project which were skipped
+	// on day in descending order of views. If reason is non-empty, only
+	// articles skipped for that reason are returned.
+	GetSkippedArticles(
+		ctx context.Context, project string, day time.Time, reason SkipReason, limit int,
+	) ([]SkippedArticle, error)
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*MemStore)(nil)
+)
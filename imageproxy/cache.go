@@ -0,0 +1,138 @@
+package imageproxy
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// imageCache is an on-disk cache of images whose total size is bounded by
+// evicting the least recently used images.
+type imageCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *imageCacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type imageCacheEntry struct {
+	key  string
+	size int64
+}
+
+// newImageCache creates an imageCache in dir, creating it if necessary.
+// Images which are already in dir are used in order of modification.
+func newImageCache(dir string, maxBytes int64) (*imageCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create image cache directory")
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read image cache directory")
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+	c := &imageCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fi := range infos {
+		if fi.IsDir() || filepath.Ext(fi.Name()) == ".tmp" {
+			continue
+		}
+		c.add(fi.Name(), fi.Size())
+	}
+	c.evict()
+	return c, nil
+}
+
+// get returns the image stored under key, if any.
+func (c *imageCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, key))
+	if err != nil {
+		// The entry may have been evicted or replaced since the lock was
+		// released, in which case it is no longer ours to remove.
+		c.mu.Lock()
+		if c.entries[key] == e {
+			c.remove(e)
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	return data, true
+}
+
+// put stores data under key and evicts images until the cache fits.
+func (c *imageCache) put(key string, data []byte) error {
+	// Each put writes its own temporary file so that concurrent puts of the
+	// same key do not interfere, and renames it while holding the lock so
+	// that the file matches the entry even if the key is evicted
+	// concurrently.
+	f, err := ioutil.TempFile(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(tmp, filepath.Join(c.dir, key)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.add(key, int64(len(data)))
+	c.evict()
+	return nil
+}
+
+func (c *imageCache) add(key string, size int64) {
+	c.entries[key] = c.lru.PushFront(&imageCacheEntry{key: key, size: size})
+	c.size += size
+}
+
+func (c *imageCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*imageCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// evict removes the least recently used images until the cache fits. The
+// files of evicted images are removed while holding the lock so that they
+// cannot be mistaken for images which are put concurrently.
+func (c *imageCache) evict() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		e := c.lru.Back()
+		os.Remove(filepath.Join(c.dir, e.Value.(*imageCacheEntry).key))
+		c.remove(e)
+	}
+}
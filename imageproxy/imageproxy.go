@@ -0,0 +1,253 @@
+// Package imageproxy serves images from other hosts resized to the widths
+// at which they are shown.
+package imageproxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif" // register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachlabs/wikifeedia/wikipedia"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// PathPrefix is the path under which a Proxy is served.
+const PathPrefix = "/img/"
+
+// Path returns the path at which a Proxy serves the image at src resized to
+// width.
+func Path(src string, width int) string {
+	return PathPrefix + strconv.Itoa(width) + "?src=" + url.QueryEscape(src)
+}
+
+// Config configures a Proxy.
+type Config struct {
+	// Dir is the directory in which resized images are cached.
+	Dir string
+	// MaxBytes bounds the total size of the cached images.
+	MaxBytes int64
+	// Widths are the widths to which images are resized. Requested widths
+	// are rounded up to one of them.
+	Widths []int
+	// AllowedHosts are the hosts from which images are retrieved.
+	AllowedHosts []string
+	// MaxSourceBytes bounds the size of the images which are retrieved.
+	MaxSourceBytes int64
+	// MaxSourcePixels bounds the width times height of the images which are
+	// decoded, since small compressed images may decode to huge ones.
+	MaxSourcePixels int64
+	// Client retrieves images. It defaults to a client with a timeout.
+	Client    *http.Client
+	UserAgent string
+}
+
+// DefaultConfig returns the configuration of a Proxy which caches images from
+// Wikimedia Commons in dir.
+func DefaultConfig(dir string) Config {
+	return Config{
+		Dir:             dir,
+		MaxBytes:        1 << 30,
+		Widths:          []int{160, 320, 640, 1280},
+		AllowedHosts:    []string{"upload.wikimedia.org"},
+		MaxSourceBytes:  50 << 20,
+		MaxSourcePixels: 50e6,
+		UserAgent:       wikipedia.DefaultUserAgent,
+	}
+}
+
+// Proxy is an http.Handler which serves images from the allowed hosts resized
+// to the requested width so that clients neither download the originals nor
+// contact the hosts directly. Resized images are cached on disk.
+type Proxy struct {
+	cfg   Config
+	hosts map[string]bool
+	cache *imageCache
+	group singleflight.Group
+}
+
+// New creates a Proxy.
+func New(cfg Config) (*Proxy, error) {
+	if len(cfg.Widths) == 0 {
+		return nil, errors.New("no image widths configured")
+	}
+	cfg.Widths = append([]int(nil), cfg.Widths...)
+	sort.Ints(cfg.Widths)
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	cache, err := newImageCache(cfg.Dir, cfg.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{cfg: cfg, hosts: make(map[string]bool), cache: cache}
+	for _, h := range cfg.AllowedHosts {
+		p.hosts[h] = true
+	}
+	return p, nil
+}
+
+// Allowed returns true if the image at src may be served by p.
+func (p *Proxy) Allowed(src string) bool {
+	u, err := url.Parse(src)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && p.hosts[u.Host]
+}
+
+// Width rounds w up to the nearest width to which p resizes images.
+func (p *Proxy) Width(w int) int {
+	i := sort.SearchInts(p.cfg.Widths, w)
+	if i == len(p.cfg.Widths) {
+		i--
+	}
+	return p.cfg.Widths[i]
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	width, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, PathPrefix))
+	if err != nil || width <= 0 {
+		http.Error(w, "invalid width", http.StatusBadRequest)
+		return
+	}
+	src := r.URL.Query().Get("src")
+	if !p.Allowed(src) {
+		http.Error(w, "image source not allowed", http.StatusForbidden)
+		return
+	}
+	data, err := p.get(src, p.Width(width))
+	if err != nil {
+		log.Printf("failed to proxy %s: %v", src, err)
+		http.Error(w, "failed to retrieve image", http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+// get returns the image at src resized to width from the cache, retrieving
+// it if necessary. Concurrent requests for the same image are coalesced, so
+// the retrieval is bounded by the timeout of the client rather than by the
+// context of any one request.
+func (p *Proxy) get(src string, width int) ([]byte, error) {
+	h := sha256.Sum256([]byte(strconv.Itoa(width) + " " + src))
+	key := hex.EncodeToString(h[:])
+	if data, ok := p.cache.get(key); ok {
+		return data, nil
+	}
+	v, err, _ := p.group.Do(key, func() (interface{}, error) {
+		data, err := p.render(src, width)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.cache.put(key, data); err != nil {
+			log.Printf("failed to cache %s: %v", src, err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// render retrieves the image at src and re-encodes it no wider than width.
+// JPEG images are re-encoded as JPEG and others as PNG.
+func (p *Proxy) render(src string, width int) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", p.cfg.UserAgent)
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	body := io.Reader(resp.Body)
+	if p.cfg.MaxSourceBytes > 0 {
+		body = io.LimitReader(body, p.cfg.MaxSourceBytes+1)
+	}
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if p.cfg.MaxSourceBytes > 0 && int64(len(buf)) > p.cfg.MaxSourceBytes {
+		return nil, fmt.Errorf("image exceeds %d bytes", p.cfg.MaxSourceBytes)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode image")
+	}
+	if p.cfg.MaxSourcePixels > 0 && int64(cfg.Width)*int64(cfg.Height) > p.cfg.MaxSourcePixels {
+		return nil, fmt.Errorf("image of %dx%d exceeds %d pixels",
+			cfg.Width, cfg.Height, p.cfg.MaxSourcePixels)
+	}
+	img, format, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode image")
+	}
+	if b := img.Bounds(); b.Dx() > width {
+		img = resize(img, width, maxInt(1, b.Dy()*width/b.Dx()))
+	}
+	var out bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&out, img)
+	}
+	return out.Bytes(), err
+}
+
+// resize scales src to width by height by averaging the pixels of src which
+// fall within each pixel of the result. It is only suitable for reducing the
+// size of images.
+func resize(src image.Image, width, height int) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := maxInt(y0+1, b.Min.Y+(y+1)*b.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := maxInt(x0+1, b.Min.X+(x+1)*b.Dx()/width)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
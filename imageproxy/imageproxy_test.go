@@ -0,0 +1,165 @@
+package imageproxy
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxy(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for x := 0; x < 800; x++ {
+		for y := 0; y < 400; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var pngBuf, jpegBuf bytes.Buffer
+	require.Nil(t, png.Encode(&pngBuf, img))
+	require.Nil(t, jpeg.Encode(&jpegBuf, img, nil))
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/a.png":
+			w.Write(pngBuf.Bytes())
+		case "/b.jpg":
+			w.Write(jpegBuf.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+	u, err := url.Parse(upstream.URL)
+	require.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "imageproxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cfg := DefaultConfig(dir)
+	cfg.AllowedHosts = []string{u.Host}
+	p, err := New(cfg)
+	require.Nil(t, err)
+	assert.Equal(t, 160, p.Width(1))
+	assert.Equal(t, 640, p.Width(600))
+	assert.Equal(t, 1280, p.Width(5000))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) (image.Config, string) {
+		cfg, format, err := image.DecodeConfig(w.Body)
+		require.Nil(t, err)
+		return cfg, format
+	}
+
+	w := get(Path(upstream.URL+"/a.png", 300))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	c, format := decode(w)
+	assert.Equal(t, "png", format)
+	assert.Equal(t, 320, c.Width)
+	assert.Equal(t, 160, c.Height)
+
+	// Resized images are served from the cache.
+	w = get(Path(upstream.URL+"/a.png", 320))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// Images are not enlarged and JPEGs remain JPEGs.
+	w = get(Path(upstream.URL+"/b.jpg", 1280))
+	require.Equal(t, http.StatusOK, w.Code)
+	c, format = decode(w)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 800, c.Width)
+
+	assert.Equal(t, http.StatusBadGateway, get(Path(upstream.URL+"/missing.png", 320)).Code)
+	assert.Equal(t, http.StatusForbidden, get(Path("https://example.com/a.png", 320)).Code)
+	assert.Equal(t, http.StatusBadRequest, get("/img/wide?src="+url.QueryEscape(upstream.URL+"/a.png")).Code)
+
+	// Images with too many pixels are not decoded.
+	dir, err = ioutil.TempDir("", "imageproxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cfg.Dir = dir
+	cfg.MaxSourcePixels = 800*400 - 1
+	p, err = New(cfg)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusBadGateway, get(Path(upstream.URL+"/a.png", 320)).Code)
+}
+
+func TestImageCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imageproxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	c, err := newImageCache(dir, 10)
+	require.Nil(t, err)
+	require.Nil(t, c.put("a", []byte("aaaa")))
+	require.Nil(t, c.put("b", []byte("bbbb")))
+	_, ok := c.get("a")
+	assert.True(t, ok)
+	// b is the least recently used.
+	require.Nil(t, c.put("c", []byte("cccc")))
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	data, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "aaaa", string(data))
+
+	// The cache is restored from disk.
+	c, err = newImageCache(dir, 10)
+	require.Nil(t, err)
+	assert.Equal(t, int64(8), c.size)
+	_, ok = c.get("c")
+	assert.True(t, ok)
+}
+
+func TestImageCacheConcurrency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imageproxy")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	c, err := newImageCache(dir, 40)
+	require.Nil(t, err)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprint((g + i) % 16)
+				if i%3 == 0 {
+					assert.Nil(t, c.put(key, bytes.Repeat([]byte{'x'}, 1+i%8)))
+				} else if data, ok := c.get(key); ok {
+					assert.NotEmpty(t, data)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var size int64
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*imageCacheEntry)
+		assert.Equal(t, e, c.entries[entry.key])
+		size += entry.size
+	}
+	assert.Equal(t, len(c.entries), c.lru.Len())
+	assert.Equal(t, size, c.size)
+	assert.True(t, c.size <= 40)
+}
@@ -10,14 +10,22 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/cockroachlabs/wikifeedia/crawler"
 	"github.com/cockroachlabs/wikifeedia/db"
+	"github.com/cockroachlabs/wikifeedia/imageproxy"
 	"github.com/cockroachlabs/wikifeedia/server"
 	"github.com/cockroachlabs/wikifeedia/wikipedia"
 	"github.com/pkg/errors"
@@ -27,6 +35,84 @@ import (
 func main() {
 	var pgURL string
 	var expandedPgURL string
+	var pageviewsURL string
+	var projectURLFormat string
+	var userAgent string
+	var requestTimeout time.Duration
+	var recordDir, replayDir string
+	var projectsFile string
+	var discoverProjects bool
+	var minArticles int
+	var familiesFlag string
+	var actionURLFormat string
+	var filterConfig string
+	imagePolicy := wikipedia.DefaultImagePolicy
+	// discover discovers the projects of the families configured by the
+	// global flags using wiki.
+	discover := func(ctx context.Context, wiki *wikipedia.Client) (*wikipedia.Registry, error) {
+		families, err := parseFamilies(familiesFlag)
+		if err != nil {
+			return nil, err
+		}
+		return wiki.DiscoverProjects(ctx, minArticles, families...)
+	}
+	// newWikiClient creates a client configured by the global flags and the
+	// --cache-dir flag of the command, if set.
+	newWikiClient := func(c *cli.Context) (*wikipedia.Client, error) {
+		opts := []wikipedia.Option{
+			wikipedia.WithPageviewsURL(pageviewsURL),
+			wikipedia.WithProjectURLFormat(projectURLFormat),
+			wikipedia.WithActionURLFormat(actionURLFormat),
+			wikipedia.WithUserAgent(userAgent),
+			wikipedia.WithRequestTimeout(requestTimeout),
+			wikipedia.WithImagePolicy(imagePolicy),
+		}
+		switch {
+		case recordDir != "" && replayDir != "":
+			return nil, errors.New("--record and --replay are mutually exclusive")
+		case recordDir != "":
+			t, err := wikipedia.NewRecordingTransport(recordDir, nil)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, wikipedia.WithHTTPClient(&http.Client{Transport: t}))
+		case replayDir != "":
+			t, err := wikipedia.NewReplayTransport(replayDir)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, wikipedia.WithHTTPClient(&http.Client{Transport: t}))
+		}
+		if filterConfig != "" {
+			filter, err := wikipedia.LoadFilter(filterConfig)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, wikipedia.WithFilter(filter))
+		}
+		if dir := c.String("cache-dir"); dir != "" {
+			cache, err := wikipedia.NewCache(dir, wikipedia.DefaultCacheTTLs)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, wikipedia.WithCache(cache))
+		}
+		switch {
+		case projectsFile != "":
+			projects, err := wikipedia.LoadRegistry(projectsFile)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, wikipedia.WithProjects(projects))
+		case discoverProjects:
+			projects, err := discover(context.Background(), wikipedia.New(opts...))
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to discover projects")
+			}
+			opts = append(opts, wikipedia.WithProjects(projects))
+		}
+		return wikipedia.New(opts...), nil
+	}
 	app := cli.NewApp()
 	app.Name = "wikifeedia"
 	app.Usage = "runs one of the main actions"
@@ -38,8 +124,94 @@ func main() {
 		cli.StringFlag{
 			Name:        "pgurl",
 			Value:       "pgurl://root@localhost:26257?sslmode=disable",
+			Usage:       `URL of the CockroachDB cluster, or "memory" to keep everything in memory for local development`,
 			Destination: &pgURL,
 		},
+		cli.StringFlag{
+			Name:        "pageviews-url",
+			Value:       wikipedia.DefaultPageviewsURL,
+			Usage:       "base URL of the wikimedia pageviews API",
+			Destination: &pageviewsURL,
+		},
+		cli.StringFlag{
+			Name:        "project-url-format",
+			Value:       wikipedia.DefaultProjectURLFormat,
+			Usage:       "format string for the base URL of a project's REST API given its domain",
+			Destination: &projectURLFormat,
+		},
+		cli.StringFlag{
+			Name:        "action-url-format",
+			Value:       wikipedia.DefaultActionURLFormat,
+			Usage:       "format string for the URL of a project's action API given its domain",
+			Destination: &actionURLFormat,
+		},
+		cli.StringFlag{
+			Name:        "filter-config",
+			Usage:       "JSON file of rules deciding which pages are left out of the feed",
+			Destination: &filterConfig,
+		},
+		cli.IntFlag{
+			Name:        "thumbnail-width",
+			Value:       imagePolicy.ThumbnailWidth,
+			Usage:       "width in pixels of the thumbnails of the images of articles",
+			Destination: &imagePolicy.ThumbnailWidth,
+		},
+		cli.IntFlag{
+			Name:        "min-image-width",
+			Value:       imagePolicy.MinWidth,
+			Usage:       "minimum width in pixels of the image of an article",
+			Destination: &imagePolicy.MinWidth,
+		},
+		cli.IntFlag{
+			Name:        "min-image-height",
+			Value:       imagePolicy.MinHeight,
+			Usage:       "minimum height in pixels of the image of an article",
+			Destination: &imagePolicy.MinHeight,
+		},
+		cli.StringFlag{
+			Name:        "user-agent",
+			Value:       wikipedia.DefaultUserAgent,
+			Usage:       "User-Agent, including contact information, sent to the wikimedia APIs",
+			Destination: &userAgent,
+		},
+		cli.DurationFlag{
+			Name:        "request-timeout",
+			Value:       30 * time.Second,
+			Usage:       "timeout for each request to the wikimedia APIs",
+			Destination: &requestTimeout,
+		},
+		cli.StringFlag{
+			Name:        "projects-file",
+			Usage:       "file containing a JSON array of the projects to serve and crawl, as written by discover-projects",
+			Destination: &projectsFile,
+		},
+		cli.BoolFlag{
+			Name:        "discover-projects",
+			Usage:       "discover the projects to serve and crawl from the wikimedia sitematrix",
+			Destination: &discoverProjects,
+		},
+		cli.IntFlag{
+			Name:        "min-articles",
+			Value:       100000,
+			Usage:       "minimum number of articles of a discovered project",
+			Destination: &minArticles,
+		},
+		cli.StringFlag{
+			Name:        "families",
+			Value:       string(wikipedia.Wikipedia),
+			Usage:       "comma-separated families of discovered projects: wikipedia, wiktionary, wikinews or wikivoyage",
+			Destination: &familiesFlag,
+		},
+		cli.StringFlag{
+			Name:        "record",
+			Usage:       "directory in which to record all traffic to the wikimedia APIs",
+			Destination: &recordDir,
+		},
+		cli.StringFlag{
+			Name:        "replay",
+			Usage:       "directory from which to replay traffic recorded with --record instead of contacting the wikimedia APIs",
+			Destination: &replayDir,
+		},
 	}
 	app.Before = cli.BeforeFunc(func(ctx *cli.Context) error {
 		expandedPgURL = os.ExpandEnv(pgURL)
@@ -47,11 +219,82 @@ func main() {
 	})
 	app.Commands = []cli.Command{
 		{
-			Name: "setup",
+			Name:        "setup",
+			Description: "Create the database and migrate its schema to the latest version",
 			Action: func(c *cli.Context) error {
 				fmt.Println("Setting up database at", pgURL)
-				_, err := db.New(expandedPgURL)
-				return err
+				return migrate(expandedPgURL, func(ctx context.Context, m *db.Migrator) error {
+					return migrateUp(ctx, m, db.LatestVersion)
+				})
+			},
+		},
+		{
+			Name:        "migrate",
+			Description: "Apply, revert or show the versioned migrations of the database schema",
+			Subcommands: []cli.Command{
+				{
+					Name:        "up",
+					Description: "Apply the pending migrations",
+					Action: func(c *cli.Context) error {
+						to := db.LatestVersion
+						if c.IsSet("to") {
+							to = c.Int("to")
+						}
+						return migrate(expandedPgURL, func(ctx context.Context, m *db.Migrator) error {
+							return migrateUp(ctx, m, to)
+						})
+					},
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "to",
+							Usage: "version to migrate to, by default the latest",
+						},
+					},
+				},
+				{
+					Name:        "down",
+					Description: "Revert applied migrations",
+					Action: func(c *cli.Context) error {
+						return migrate(expandedPgURL, func(ctx context.Context, m *db.Migrator) error {
+							to := -1
+							if c.IsSet("to") {
+								to = c.Int("to")
+							}
+							if to < 0 {
+								version, err := m.Version(ctx)
+								if err != nil {
+									return err
+								}
+								to = version - 1
+							}
+							reverted, err := m.Down(ctx, to)
+							for _, mig := range reverted {
+								fmt.Printf("reverted %d: %s\n", mig.Version, mig.Name)
+							}
+							return err
+						})
+					},
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "to",
+							Usage: "version to migrate to, by default the version before the current one",
+						},
+					},
+				},
+				{
+					Name:        "status",
+					Description: "Show which migrations have been applied",
+					Action: func(c *cli.Context) error {
+						return migrate(expandedPgURL, func(ctx context.Context, m *db.Migrator) error {
+							status, err := m.Status(ctx)
+							if err != nil {
+								return err
+							}
+							printMigrationStatus(os.Stdout, status)
+							return nil
+						})
+					},
+				},
 			},
 		},
 		{
@@ -59,13 +302,208 @@ func main() {
 			Description: "Update the set of articles one time",
 			Action: func(c *cli.Context) error {
 				fmt.Println("Setting up database at", pgURL)
-				conn, err := db.New(expandedPgURL)
+				conn, err := openStore(expandedPgURL)
+				if err != nil {
+					return err
+				}
+				wiki, err := newWikiClient(c)
+				if err != nil {
+					return err
+				}
+				granularity, err := wikipedia.ParseGranularity(c.String("views-granularity"))
+				if err != nil {
+					return err
+				}
+				opts := []crawler.Option{
+					crawler.WithViewHistory(granularity, c.Duration("views-window")),
+					crawler.WithParallelism(c.Int("parallelism")),
+				}
+				if url := c.String("prewarm-images"); url != "" {
+					opts = append(opts, crawler.WithImagePrewarm(url))
+				}
+				crawl := crawler.New(conn, wiki, opts...)
+				ctx, cancel := signalContext()
+				defer cancel()
+				if c.Bool("daemon") {
+					return runCrawlDaemon(ctx, c, crawl, wiki.Projects())
+				}
+				if c.IsSet("from") || c.IsSet("to") {
+					from, err := parseDate(c.String("from"))
+					if err != nil {
+						return errors.Wrap(err, "invalid --from")
+					}
+					to, err := parseDate(c.String("to"))
+					if err != nil {
+						return errors.Wrap(err, "invalid --to")
+					}
+					reports, err := crawl.CrawlRange(ctx, from, to)
+					for _, r := range reports {
+						fmt.Print(r)
+					}
+					return err
+				}
+				if c.IsSet("date") {
+					date, err := parseDate(c.String("date"))
+					if err != nil {
+						return errors.Wrap(err, "invalid --date")
+					}
+					r, err := crawl.CrawlDate(ctx, date)
+					fmt.Print(r)
+					return err
+				}
+				r, err := crawl.CrawlOnce(ctx)
+				fmt.Print(r)
+				return err
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "date",
+					Usage: "crawl the top articles of the given day (YYYY-MM-DD) instead of yesterday",
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "first day (YYYY-MM-DD) of a range of days to backfill",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "last day (YYYY-MM-DD) of a range of days to backfill",
+				},
+				cli.StringFlag{
+					Name:  "views-granularity",
+					Value: string(wikipedia.Daily),
+					Usage: "granularity of the stored views of each article: daily or hourly",
+				},
+				cli.DurationFlag{
+					Name:  "views-window",
+					Value: 30 * 24 * time.Hour,
+					Usage: "window of views to store for each article, zero disables",
+				},
+				cli.IntFlag{
+					Name:  "parallelism",
+					Value: 4,
+					Usage: "number of projects to crawl concurrently",
+				},
+				cli.BoolFlag{
+					Name:  "daemon",
+					Usage: "crawl continuously on a schedule rather than once",
+				},
+				cli.DurationFlag{
+					Name:  "interval",
+					Value: time.Hour,
+					Usage: "time between crawls of each project in daemon mode",
+				},
+				cli.StringSliceFlag{
+					Name:  "project-interval",
+					Usage: "per-project override of --interval as project=duration, may be repeated",
+				},
+				cli.DurationFlag{
+					Name:  "jitter",
+					Value: 5 * time.Minute,
+					Usage: "maximum random delay added to each interval in daemon mode",
+				},
+				cli.DurationFlag{
+					Name:  "max-run-time",
+					Value: 45 * time.Minute,
+					Usage: "maximum duration of a crawl of a project in daemon mode, zero disables",
+				},
+				cli.StringFlag{
+					Name:  "status-addr",
+					Value: ":8081",
+					Usage: "address on which to serve /healthz and /status in daemon mode",
+				},
+				cli.StringFlag{
+					Name:  "prewarm-images",
+					Usage: "base URL of a server whose image proxy to warm with the images of new articles",
+				},
+				cacheDirFlag,
+			},
+		},
+		{
+			Name:        "crawl-status",
+			Description: "Show the most recent crawls of each project",
+			Action: func(c *cli.Context) error {
+				conn, err := openStore(expandedPgURL)
+				if err != nil {
+					return err
+				}
+				ctx := context.Background()
+				var runs []db.CrawlRun
+				if project := c.String("project"); project != "" {
+					runs, err = conn.GetCrawlRuns(ctx, project, c.Int("limit"))
+				} else {
+					runs, err = conn.GetLatestCrawlRuns(ctx)
+				}
+				if err != nil {
+					return err
+				}
+				printCrawlRuns(os.Stdout, runs)
+				return nil
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "project",
+					Usage: "show the history of a single project rather than the latest crawl of each",
+				},
+				cli.IntFlag{
+					Name:  "limit",
+					Value: 20,
+					Usage: "number of crawls to show with --project",
+				},
+			},
+		},
+		{
+			Name:        "skipped-articles",
+			Description: "Show the top articles which the crawler left out of the feed and why",
+			Action: func(c *cli.Context) error {
+				day := wikipedia.Yesterday()
+				if c.IsSet("date") {
+					var err error
+					if day, err = parseDate(c.String("date")); err != nil {
+						return errors.Wrap(err, "invalid --date")
+					}
+				}
+				var reason db.SkipReason
+				if c.IsSet("reason") {
+					var err error
+					if reason, err = db.ParseSkipReason(c.String("reason")); err != nil {
+						return err
+					}
+				}
+				conn, err := openStore(expandedPgURL)
+				if err != nil {
+					return err
+				}
+				skipped, err := conn.GetSkippedArticles(context.Background(),
+					c.String("project"), day, reason, c.Int("limit"))
 				if err != nil {
 					return err
 				}
-				wiki := wikipedia.New()
-				crawl := crawler.New(conn, wiki)
-				return crawl.CrawlOnce(context.Background())
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "ARTICLE\tVIEWS\tREASON\tDETAIL")
+				for _, s := range skipped {
+					fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", s.Article, s.Views, s.Reason, s.Detail)
+				}
+				return tw.Flush()
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "project",
+					Value: "en",
+					Usage: "project whose skipped articles to show",
+				},
+				cli.StringFlag{
+					Name:  "date",
+					Usage: "day (YYYY-MM-DD) of the crawled top articles, defaults to yesterday",
+				},
+				cli.StringFlag{
+					Name:  "reason",
+					Usage: "only show articles skipped for this reason: no_extract, no_image, fetch_error, filtered, disambiguation, main_page or redirect",
+				},
+				cli.IntFlag{
+					Name:  "limit",
+					Value: 100,
+					Usage: "maximum number of articles to show",
+				},
 			},
 		},
 		{
@@ -73,15 +511,58 @@ func main() {
 			Description: "Run the server",
 			Action: func(c *cli.Context) error {
 				fmt.Println("Setting up database at", pgURL)
-				conn, err := db.New(expandedPgURL)
+				conn, err := openStore(expandedPgURL)
 				if err != nil {
 					return err
 				}
-				h := server.New(conn)
+				wiki, err := newWikiClient(c)
+				if err != nil {
+					return err
+				}
+				var opts []server.Option
+				if dir := c.String("image-cache-dir"); dir != "" {
+					cfg := imageproxy.DefaultConfig(dir)
+					cfg.MaxBytes = c.Int64("image-cache-size")
+					cfg.UserAgent = userAgent
+					images, err := imageproxy.New(cfg)
+					if err != nil {
+						return err
+					}
+					opts = append(opts, server.WithImageProxy(images))
+				}
+				ctx, cancel := signalContext()
+				// The scheduler is stopped along with the server, and the
+				// process only exits once its crawls have returned.
+				schedDone := make(chan struct{})
+				if interval := c.Duration("crawl-interval"); interval > 0 {
+					sched := crawler.NewScheduler(crawler.New(conn, wiki), crawler.ScheduleConfig{
+						Projects: wiki.Projects().Codes(),
+						Interval: interval,
+					})
+					go func() {
+						defer close(schedDone)
+						if err := sched.Run(ctx); err != nil && err != context.Canceled {
+							log.Printf("scheduler stopped: %v", err)
+						}
+					}()
+				} else {
+					close(schedDone)
+				}
+				defer func() {
+					cancel()
+					<-schedDone
+				}()
+				h := server.New(conn, wiki.Projects(), opts...)
 				server := http.Server{
 					Addr:    fmt.Sprintf(":%d", c.Int("port")),
 					Handler: h,
 				}
+				go func() {
+					<-ctx.Done()
+					shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					server.Shutdown(shutdownCtx)
+				}()
 				if !c.Bool("insecure") {
 					priv, certBytes, err := generateCertificate()
 					if err != nil {
@@ -113,16 +594,118 @@ func main() {
 					Name:  "insecure",
 					Usage: "disables TLS",
 				},
+				cli.StringFlag{
+					Name:  "image-cache-dir",
+					Usage: "directory in which to cache images served by the image proxy at /img/, empty disables the proxy",
+				},
+				cli.Int64Flag{
+					Name:  "image-cache-size",
+					Value: 1 << 30,
+					Usage: "maximum size in bytes of the image cache",
+				},
+				cli.DurationFlag{
+					Name:  "crawl-interval",
+					Usage: "if non-zero, also crawl every project into the store of the server at this interval, e.g. with --pgurl=memory",
+				},
+				cacheDirFlag,
+			},
+		},
+		{
+			Name:        "discover-projects",
+			Description: "Print the projects discovered from the wikimedia sitematrix as JSON for use with --projects-file",
+			Action: func(c *cli.Context) error {
+				ctx, cancel := signalContext()
+				defer cancel()
+				wiki, err := newWikiClient(c)
+				if err != nil {
+					return err
+				}
+				projects, err := discover(ctx, wiki)
+				if err != nil {
+					return err
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(projects.Projects())
+			},
+		},
+		{
+			Name:        "filter-test",
+			Usage:       "filter-test [titles...]",
+			Description: "Show whether pages are left out of the feed by the filter rules",
+			Action: func(c *cli.Context) error {
+				ctx, cancel := signalContext()
+				defer cancel()
+				wiki, err := newWikiClient(c)
+				if err != nil {
+					return err
+				}
+				project := c.String("project")
+				titles := []string(c.Args())
+				if len(titles) == 0 {
+					date := wikipedia.Yesterday()
+					if c.IsSet("date") {
+						if date, err = parseDate(c.String("date")); err != nil {
+							return errors.Wrap(err, "invalid --date")
+						}
+					}
+					top, err := wiki.FetchTopArticlesForDate(ctx, project, date, wikipedia.AllAccess)
+					if err != nil {
+						return err
+					}
+					for _, a := range append(top.Filtered, top.Articles...) {
+						titles = append(titles, a.Article)
+					}
+				}
+				tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(tw, "TITLE\tDECISION\tRULE")
+				for _, title := range titles {
+					d, err := checkTitle(ctx, wiki, project, title)
+					if err != nil {
+						return err
+					}
+					decision := "allow"
+					if !d.Allowed {
+						decision = "deny"
+					}
+					fmt.Fprintf(tw, "%s\t%s\t%s\n", title, decision, d.Rule)
+				}
+				return tw.Flush()
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "project",
+					Value: "en",
+					Usage: "project of the pages",
+				},
+				cli.StringFlag{
+					Name:  "date",
+					Usage: "day (YYYY-MM-DD) of the top articles to test if no titles are given, defaults to yesterday",
+				},
 			},
 		},
 		{
 			Name:        "fetch-top-articles",
 			Description: "debug command to exercise the wikipedia client functionality.",
 			Action: func(c *cli.Context) error {
-				ctx := context.Background()
-				wiki := wikipedia.New()
+				ctx, cancel := signalContext()
+				defer cancel()
+				wiki, err := newWikiClient(c)
+				if err != nil {
+					return err
+				}
 				project := c.String("project")
-				top, err := wiki.FetchTopArticles(ctx, project)
+				date := wikipedia.Yesterday()
+				if c.IsSet("date") {
+					if date, err = parseDate(c.String("date")); err != nil {
+						return errors.Wrap(err, "invalid --date")
+					}
+				}
+				access, err := wikipedia.ParseAccess(c.String("access"))
+				if err != nil {
+					return err
+				}
+				top, err := wiki.FetchTopArticlesForDate(ctx, project, date, access)
 				if err != nil {
 					return err
 				}
@@ -150,6 +733,16 @@ func main() {
 					Value: "en",
 					Usage: "project to scan",
 				},
+				cli.StringFlag{
+					Name:  "date",
+					Usage: "day (YYYY-MM-DD) of the top articles, defaults to yesterday",
+				},
+				cli.StringFlag{
+					Name:  "access",
+					Value: string(wikipedia.AllAccess),
+					Usage: "access type: all-access, desktop, mobile-app or mobile-web",
+				},
+				cacheDirFlag,
 			},
 		},
 	}
@@ -159,6 +752,173 @@ func main() {
 	}
 }
 
+// cacheDirFlag enables the on-disk cache of responses from the wikimedia APIs.
+var cacheDirFlag = cli.StringFlag{
+	Name:  "cache-dir",
+	Usage: "directory in which to cache responses from the wikimedia APIs, empty disables",
+}
+
+// runCrawlDaemon crawls on a schedule and serves the status of the crawls
+// until ctx is canceled.
+func runCrawlDaemon(
+	ctx context.Context, c *cli.Context, crawl *crawler.Crawler, projects *wikipedia.Registry,
+) error {
+	projectIntervals := make(map[string]time.Duration)
+	for _, pi := range c.StringSlice("project-interval") {
+		parts := strings.SplitN(pi, "=", 2)
+		if len(parts) != 2 || !projects.Has(parts[0]) {
+			return fmt.Errorf("invalid --project-interval %q", pi)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return errors.Wrapf(err, "invalid --project-interval %q", pi)
+		}
+		projectIntervals[parts[0]] = d
+	}
+	sched := crawler.NewScheduler(crawl, crawler.ScheduleConfig{
+		Projects:         projects.Codes(),
+		Interval:         c.Duration("interval"),
+		ProjectIntervals: projectIntervals,
+		Jitter:           c.Duration("jitter"),
+		MaxRunTime:       c.Duration("max-run-time"),
+		Parallelism:      c.Int("parallelism"),
+	})
+	statusServer := http.Server{
+		Addr:    c.String("status-addr"),
+		Handler: sched,
+	}
+	errCh := make(chan error, 1)
+	go func() { errCh <- statusServer.ListenAndServe() }()
+	go func() {
+		if err := sched.Run(ctx); err != nil && err != context.Canceled {
+			log.Printf("scheduler stopped: %v", err)
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		statusServer.Shutdown(shutdownCtx)
+	}()
+	if err := <-errCh; err != nil && err != http.ErrServerClosed {
+		return errors.Wrap(err, "failed to serve status")
+	}
+	return nil
+}
+
+// memoryPgURL is the value of --pgurl which selects a db.MemStore.
+const memoryPgURL = "memory"
+
+// openStore opens the store at pgurl.
+func openStore(pgurl string) (db.Store, error) {
+	if pgurl == memoryPgURL {
+		return db.NewMemStore(), nil
+	}
+	return db.New(pgurl)
+}
+
+// migrate runs f with a Migrator for the database at pgurl.
+func migrate(pgurl string, f func(context.Context, *db.Migrator) error) error {
+	m, err := db.NewMigrator(pgurl)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	ctx, cancel := signalContext()
+	defer cancel()
+	return f(ctx, m)
+}
+
+// migrateUp applies the pending migrations up to version to.
+func migrateUp(ctx context.Context, m *db.Migrator, to int) error {
+	applied, err := m.Up(ctx, to)
+	for _, mig := range applied {
+		fmt.Printf("applied %d: %s\n", mig.Version, mig.Name)
+	}
+	return err
+}
+
+// printMigrationStatus writes a table describing status to w.
+func printMigrationStatus(w io.Writer, status []db.MigrationStatus) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	for _, s := range status {
+		applied := "pending"
+		if !s.Applied.IsZero() {
+			applied = s.Applied.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	tw.Flush()
+}
+
+// printCrawlRuns writes a table describing runs to w.
+func printCrawlRuns(w io.Writer, runs []db.CrawlRun) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROJECT\tDAY\tSTARTED\tAGE\tDURATION\tFETCHED\tSKIPPED\tFAILED\tERROR")
+	now := time.Now()
+	for _, r := range runs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%v\t%d\t%d\t%d\t%s\n",
+			r.Project,
+			r.Day.Format("2006-01-02"),
+			r.Started.Format(time.RFC3339),
+			now.Sub(r.Started).Round(time.Minute),
+			r.Finished.Sub(r.Started).Round(time.Second),
+			r.Fetched, r.Skipped, r.Failed, r.Error)
+	}
+	tw.Flush()
+}
+
+// checkTitle decides whether the page with title in project is kept in the
+// feed. The article is retrieved to check its Wikidata classes if the filter
+// has rules which match them.
+func checkTitle(
+	ctx context.Context, wiki *wikipedia.Client, project, title string,
+) (wikipedia.FilterDecision, error) {
+	d := wiki.CheckTitle(ctx, project, title)
+	if !d.Allowed || !wiki.Filter().NeedsClasses() {
+		return d, nil
+	}
+	a, err := wiki.GetArticle(ctx, project, title)
+	if err != nil {
+		return wikipedia.FilterDecision{}, err
+	}
+	return wiki.CheckArticle(ctx, project, &a)
+}
+
+// parseFamilies parses a comma-separated list of families.
+func parseFamilies(s string) ([]wikipedia.Family, error) {
+	var families []wikipedia.Family
+	for _, name := range strings.Split(s, ",") {
+		f, err := wikipedia.ParseFamily(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		families = append(families, f)
+	}
+	return families, nil
+}
+
+// parseDate parses a day in the format YYYY-MM-DD.
+func parseDate(s string) (time.Time, error) {
+	return time.Parse("2006-01-02", s)
+}
+
+// signalContext returns a context which is canceled when the process receives
+// SIGINT or SIGTERM.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			fmt.Fprintf(os.Stderr, "received %v, shutting down\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigCh)
+	}()
+	return ctx, cancel
+}
+
 func generateCertificate() (crypto.PrivateKey, []byte, error) {
 	// Loosely based on https://golang.org/src/crypto/tls/generate_cert.go
 	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -9,6 +9,7 @@ import (
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/cockroachlabs/wikifeedia/db"
+	"github.com/cockroachlabs/wikifeedia/imageproxy"
 	"github.com/cockroachlabs/wikifeedia/wikipedia"
 	"github.com/samsarahq/thunder/graphql"
 	"github.com/samsarahq/thunder/graphql/graphiql"
@@ -20,14 +21,29 @@ import (
 
 // Server is an http.Handler for a graphql server for this application.
 type Server struct {
-	db  *db.DB
-	mux http.ServeMux
+	db       db.Store
+	projects *wikipedia.Registry
+	images   *imageproxy.Proxy
+	mux      http.ServeMux
 }
 
-// New creates a new Server.
-func New(conn *db.DB) *Server {
+// Option configures a Server.
+type Option func(*Server)
+
+// WithImageProxy serves images through p and points the thumbnails of
+// articles at it.
+func WithImageProxy(p *imageproxy.Proxy) Option {
+	return func(s *Server) { s.images = p }
+}
+
+// New creates a new Server which serves the feeds of projects.
+func New(conn db.Store, projects *wikipedia.Registry, opts ...Option) *Server {
 	s := &Server{
-		db: conn,
+		db:       conn,
+		projects: projects,
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 	schema := s.schema()
 
@@ -49,6 +65,9 @@ func New(conn *db.DB) *Server {
 		fs.ServeHTTP(w, r)
 	}))
 	s.mux.Handle("/", staticHandler)
+	if s.images != nil {
+		s.mux.Handle(imageproxy.PathPrefix, s.images)
+	}
 	s.mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
 		if _, err := w.Write([]byte("OK")); err != nil {
@@ -70,11 +89,17 @@ type ArticlesResponse struct {
 func (s *Server) getArticles(
 	ctx context.Context,
 	args struct {
-		Project      string
-		Offset       int32
-		Limit        int32
+		Project string
+		Offset  int32
+		Limit   int32
+		OrderBy *db.OrderBy
+		// InstanceOf restricts the articles to those whose subject is an
+		// instance of the given Wikidata class, e.g. "Q5" (human).
+		InstanceOf   *string
 		FollowerRead *bool
-		AsOf         *string
+		// AsOf is the asOf of a previous response, or an RFC 3339 time, as
+		// of which follower reads are served.
+		AsOf *string
 	},
 ) (*ArticlesResponse, error) {
 	start := time.Now()
@@ -82,33 +107,136 @@ func (s *Server) getArticles(
 	if args.AsOf != nil {
 		asOf = *args.AsOf
 	}
+	orderBy := db.OrderByTrending
+	if args.OrderBy != nil {
+		orderBy = *args.OrderBy
+	}
+	var instanceOf string
+	if args.InstanceOf != nil {
+		instanceOf = *args.InstanceOf
+	}
+	followerRead := args.FollowerRead != nil && *args.FollowerRead
 	defer func() {
-		log.Printf("%v?limit=%v&offset=%v&follower_read=%v&as_of=%v - %v",
-			args.Project, args.Limit, args.Offset, *args.FollowerRead,
+		log.Printf("%v?limit=%v&offset=%v&order_by=%v&instance_of=%v&follower_read=%v&as_of=%v - %v",
+			args.Project, args.Limit, args.Offset, orderBy, instanceOf, followerRead,
 			asOf, time.Since(start))
 	}()
-	if !wikipedia.IsProject(args.Project) {
-		return nil, fmt.Errorf("%s is not a valid project")
+	if !s.projects.Has(args.Project) {
+		return nil, fmt.Errorf("%s is not a valid project", args.Project)
+	}
+	if args.Offset < 0 || args.Limit < 0 {
+		return nil, fmt.Errorf("offset and limit must not be negative")
 	}
 	articles, newAsOf, err := s.db.GetArticles(ctx, args.Project, int(args.Offset), int(args.Limit),
-		args.FollowerRead != nil && *args.FollowerRead, asOf)
+		orderBy, instanceOf, followerRead, asOf)
 	if err != nil {
 		return nil, err
 	}
+	if s.images != nil {
+		for i := range articles {
+			s.rewriteThumbnail(&articles[i])
+		}
+	}
 	return &ArticlesResponse{
 		AsOf:     newAsOf,
 		Articles: articles,
 	}, nil
 }
 
+// rewriteThumbnail points the thumbnail of a at the image proxy.
+func (s *Server) rewriteThumbnail(a *db.Article) {
+	if !s.images.Allowed(a.ImageURL) {
+		return
+	}
+	width := s.images.Width(a.ThumbnailWidth)
+	a.ThumbnailURL = imageproxy.Path(a.ImageURL, width)
+	if a.ImageWidth > 0 && a.ImageHeight > 0 {
+		a.ThumbnailWidth, a.ThumbnailHeight = a.ImageWidth, a.ImageHeight
+		if width < a.ImageWidth {
+			a.ThumbnailWidth, a.ThumbnailHeight = width, a.ImageHeight*width/a.ImageWidth
+		}
+	}
+}
+
+func (s *Server) getArticleViews(
+	ctx context.Context,
+	a *db.Article,
+	args struct {
+		Granularity *db.Granularity
+		From        *time.Time
+		To          *time.Time
+	},
+) ([]db.ArticleViews, error) {
+	granularity := db.Daily
+	if args.Granularity != nil {
+		granularity = *args.Granularity
+	}
+	to := time.Now().UTC()
+	if args.To != nil {
+		to = *args.To
+	}
+	from := to.Add(-30 * 24 * time.Hour)
+	if args.From != nil {
+		from = *args.From
+	}
+	return s.db.GetArticleViews(ctx, a.Project, a.Article, granularity, from, to)
+}
+
+// getCrawlRuns returns the recent crawls of a project, or the latest crawl of
+// each project if no project is specified.
+func (s *Server) getCrawlRuns(
+	ctx context.Context,
+	args struct {
+		Project *string
+		Limit   *int32
+	},
+) ([]db.CrawlRun, error) {
+	if args.Project == nil {
+		return s.db.GetLatestCrawlRuns(ctx)
+	}
+	if !s.projects.Has(*args.Project) {
+		return nil, fmt.Errorf("%s is not a valid project", *args.Project)
+	}
+	limit := 20
+	if args.Limit != nil {
+		limit = int(*args.Limit)
+	}
+	return s.db.GetCrawlRuns(ctx, *args.Project, limit)
+}
+
+// getProjects returns the projects whose feeds are served.
+func (s *Server) getProjects() []wikipedia.ProjectInfo {
+	return s.projects.Projects()
+}
+
 // schema builds the graphql schema.
 func (s *Server) schema() *graphql.Schema {
 	builder := schemabuilder.NewSchema()
 	obj := builder.Object("Article", db.Article{})
 	obj.Key("article")
+	obj.FieldFunc("views", s.getArticleViews)
+	builder.Object("Entity", db.Entity{})
+	builder.Object("EntityClass", db.EntityClass{})
+	builder.Object("Coordinates", db.Coordinates{})
+	builder.Object("EntityDate", db.EntityDate{})
+	builder.Enum(db.Daily, map[string]interface{}{
+		"HOURLY": db.Hourly,
+		"DAILY":  db.Daily,
+	})
+	builder.Object("ArticleViews", db.ArticleViews{})
+	builder.Enum(db.OrderByTrending, map[string]interface{}{
+		"TRENDING": db.OrderByTrending,
+		"VIEWS":    db.OrderByViews,
+		"RECENT":   db.OrderByRecent,
+	})
 	builder.Object("ArticlesResponse", ArticlesResponse{})
 	q := builder.Query()
 	q.FieldFunc("articles", s.getArticles)
+	builder.Object("CrawlRun", db.CrawlRun{})
+	builder.Object("SkipCount", db.SkipCount{})
+	q.FieldFunc("crawlRuns", s.getCrawlRuns)
+	builder.Object("Project", wikipedia.ProjectInfo{})
+	q.FieldFunc("projects", s.getProjects)
 	mut := builder.Mutation()
 	mut.FieldFunc("echo", func(args struct{ Message string }) string {
 		return args.Message
@@ -0,0 +1,173 @@
+package wikipedia
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CacheTTLs configures how long responses from each kind of endpoint are
+// served from a Cache before they are revalidated with the API.
+type CacheTTLs struct {
+	// TopArticles applies to the top articles of a day.
+	TopArticles time.Duration
+	// ArticleViews applies to the pageviews series of an article.
+	ArticleViews time.Duration
+	// Summary applies to article summaries.
+	Summary time.Duration
+	// Media applies to the media lists of articles.
+	Media time.Duration
+}
+
+// DefaultCacheTTLs are the CacheTTLs used by NewCache unless configured
+// otherwise. The top articles of a day do not change once published, whereas
+// article content may be edited at any time.
+var DefaultCacheTTLs = CacheTTLs{
+	TopArticles:  24 * time.Hour,
+	ArticleViews: 6 * time.Hour,
+	Summary:      time.Hour,
+	Media:        time.Hour,
+}
+
+// ttl returns the TTL of responses from url.
+func (t CacheTTLs) ttl(url string) time.Duration {
+	switch {
+	case strings.Contains(url, "/metrics/pageviews/top/"):
+		return t.TopArticles
+	case strings.Contains(url, "/metrics/pageviews/per-article/"):
+		return t.ArticleViews
+	case strings.Contains(url, "/page/summary/"):
+		return t.Summary
+	case strings.Contains(url, "/page/media-list/"):
+		return t.Media
+	default:
+		return 0
+	}
+}
+
+// Cache stores successful API responses on disk keyed by URL. Responses are
+// served from the Cache until their TTL expires, after which they are
+// revalidated with the API using their ETag, if any. A Cache is safe for
+// concurrent use by multiple goroutines and processes.
+type Cache struct {
+	dir  string
+	ttls CacheTTLs
+	now  func() time.Time
+}
+
+// NewCache creates a Cache which stores responses in dir, creating it if
+// necessary.
+func NewCache(dir string, ttls CacheTTLs) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create cache directory")
+	}
+	return &Cache{dir: dir, ttls: ttls, now: time.Now}, nil
+}
+
+// WithCache configures the Client to serve responses from cache.
+func WithCache(cache *Cache) Option {
+	return func(c *Client) { c.cache = cache }
+}
+
+// cacheEntry is the representation of a response on disk.
+type cacheEntry struct {
+	URL    string          `json:"url"`
+	ETag   string          `json:"etag,omitempty"`
+	Stored time.Time       `json:"stored"`
+	Body   json.RawMessage `json:"body"`
+}
+
+func (c *Cache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get returns the entry for url if there is one. Unreadable entries are
+// treated as missing.
+func (c *Cache) get(url string) (e cacheEntry, ok bool) {
+	buf, err := ioutil.ReadFile(c.path(url))
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	if err := json.Unmarshal(buf, &e); err != nil || e.URL != url {
+		return cacheEntry{}, false
+	}
+	return e, true
+}
+
+// fresh returns true if e may be served without revalidation.
+func (c *Cache) fresh(e cacheEntry) bool {
+	return c.now().Sub(e.Stored) < c.ttls.ttl(e.URL)
+}
+
+// put stores e.
+func (c *Cache) put(e cacheEntry) error {
+	e.Stored = c.now()
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path(e.URL), buf)
+}
+
+// writeFileAtomic writes buf to a temporary file which is renamed to path so
+// that concurrent readers never observe a partially written file.
+func writeFileAtomic(path string, buf []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// getJSONCached is like getJSONIfNoneMatch but serves responses from the
+// cache of the client when possible.
+func (c *Client) getJSONCached(
+	ctx context.Context, limiter *AdaptiveLimiter, url, etag string, v interface{},
+) (newETag string, notModified bool, _ error) {
+	e, cached := c.cache.get(url)
+	if !cached || !c.cache.fresh(e) {
+		// Revalidate the cached response, if any, rather than the one
+		// identified by the caller so that the body is always available.
+		var body json.RawMessage
+		respETag, respNotModified, err := c.getJSONWithRetries(ctx, limiter, url, e.ETag, &body)
+		if err != nil {
+			return "", false, err
+		}
+		if !respNotModified {
+			e = cacheEntry{URL: url, ETag: respETag, Body: body}
+		}
+		// The response is still served if it cannot be cached; it is
+		// merely fetched again next time.
+		if err := c.cache.put(e); err != nil {
+			log.Printf("failed to cache response from %s: %v", url, err)
+		}
+	}
+	if etag != "" && e.ETag == etag {
+		return e.ETag, true, nil
+	}
+	err := json.Unmarshal(e.Body, v)
+	return e.ETag, false, errors.Wrapf(err, "failed to decode cached response from %s", url)
+}
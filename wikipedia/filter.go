@@ -0,0 +1,312 @@
+package wikipedia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FilterAction is the action of a FilterRule.
+type FilterAction string
+
+// FilterAction values.
+const (
+	// Allow keeps matching pages in the feed.
+	Allow FilterAction = "allow"
+	// Deny removes matching pages from the feed.
+	Deny FilterAction = "deny"
+)
+
+// FilterRule decides whether pages which it matches are kept in the feed. A
+// rule matches a page if it applies to the project of the page and each of
+// its non-empty matchers matches the page.
+type FilterRule struct {
+	// Name describes the rule. It defaults to a description of its matchers.
+	Name   string       `json:"name,omitempty"`
+	Action FilterAction `json:"action"`
+	// Projects restricts the rule to the given projects. Empty means every
+	// project.
+	Projects []string `json:"projects,omitempty"`
+
+	// Regex matches titles, with underscores, against a regular expression.
+	Regex string `json:"regex,omitempty"`
+	// Namespaces matches pages in the namespaces with the given canonical
+	// names, e.g. "File". "*" matches every namespace other than the main
+	// namespace.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Titles matches pages with the given titles, with or without
+	// underscores.
+	Titles []string `json:"titles,omitempty"`
+	// MainPage matches the main page of the project.
+	MainPage bool `json:"main_page,omitempty"`
+	// WikidataClasses matches pages whose Wikidata item is an instance of one
+	// of the given classes, e.g. "Q4167410" for disambiguation pages.
+	WikidataClasses []string `json:"wikidata_classes,omitempty"`
+}
+
+// FilterConfig is the format of a filter configuration file.
+type FilterConfig struct {
+	// Rules are evaluated in order and the first matching rule decides
+	// whether a page is kept. Pages which match no rule are kept.
+	Rules []FilterRule `json:"rules"`
+}
+
+// DefaultFilterRules remove the main page and pages outside of the main
+// namespace, such as special pages, from the feed.
+var DefaultFilterRules = []FilterRule{
+	{Name: "main page", Action: Deny, MainPage: true},
+	{Name: "not an article", Action: Deny, Namespaces: []string{"*"}},
+	{Name: "placeholder", Action: Deny, Titles: []string{"-"}},
+}
+
+// Page is the subject of a Filter.
+type Page struct {
+	Project   string
+	Title     string
+	Namespace Namespace
+	MainPage  bool
+	// Classes are the Wikidata classes of the page, if known.
+	Classes []string
+}
+
+// FilterDecision is the outcome of evaluating a Filter.
+type FilterDecision struct {
+	Allowed bool
+	// Rule is the name of the rule which decided, or empty if no rule
+	// matched.
+	Rule string
+}
+
+type compiledRule struct {
+	FilterRule
+	regex      *regexp.Regexp
+	projects   map[string]bool
+	namespaces map[string]bool
+	titles     map[string]bool
+	classes    map[string]bool
+}
+
+// Filter decides which pages are kept in the feed.
+type Filter struct {
+	rules []compiledRule
+}
+
+// NewFilter compiles rules into a Filter.
+func NewFilter(rules []FilterRule) (*Filter, error) {
+	f := &Filter{}
+	for i, r := range rules {
+		cr := compiledRule{FilterRule: r}
+		if r.Action != Allow && r.Action != Deny {
+			return nil, fmt.Errorf("rule %d: invalid action %q", i, r.Action)
+		}
+		if r.Regex != "" {
+			var err error
+			if cr.regex, err = regexp.Compile(r.Regex); err != nil {
+				return nil, errors.Wrapf(err, "rule %d", i)
+			}
+		}
+		if r.Regex == "" && len(r.Namespaces) == 0 && len(r.Titles) == 0 &&
+			!r.MainPage && len(r.WikidataClasses) == 0 {
+			return nil, fmt.Errorf("rule %d: no matchers", i)
+		}
+		cr.projects = stringSet(r.Projects, nil)
+		cr.namespaces = stringSet(r.Namespaces, strings.ToLower)
+		cr.titles = stringSet(r.Titles, normalizeTitle)
+		cr.classes = stringSet(r.WikidataClasses, nil)
+		if cr.Name == "" {
+			cr.Name = describeRule(r)
+		}
+		f.rules = append(f.rules, cr)
+	}
+	return f, nil
+}
+
+// DefaultFilter returns a Filter of DefaultFilterRules.
+func DefaultFilter() *Filter {
+	f, err := NewFilter(DefaultFilterRules)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// LoadFilter reads a FilterConfig from a JSON file and compiles it.
+func LoadFilter(path string) (*Filter, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg FilterConfig
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse filter rules from %s", path)
+	}
+	f, err := NewFilter(cfg.Rules)
+	return f, errors.Wrapf(err, "invalid filter rules in %s", path)
+}
+
+func stringSet(values []string, normalize func(string) string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		if normalize != nil {
+			v = normalize(v)
+		}
+		set[v] = true
+	}
+	return set
+}
+
+func describeRule(r FilterRule) string {
+	var parts []string
+	if r.MainPage {
+		parts = append(parts, "main page")
+	}
+	if len(r.Namespaces) > 0 {
+		parts = append(parts, "namespace "+strings.Join(r.Namespaces, "|"))
+	}
+	if len(r.Titles) > 0 {
+		parts = append(parts, "title "+strings.Join(r.Titles, "|"))
+	}
+	if r.Regex != "" {
+		parts = append(parts, "regex "+r.Regex)
+	}
+	if len(r.WikidataClasses) > 0 {
+		parts = append(parts, "class "+strings.Join(r.WikidataClasses, "|"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// NeedsClasses returns true if any rule matches Wikidata classes.
+func (f *Filter) NeedsClasses() bool {
+	for _, r := range f.rules {
+		if len(r.classes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Check decides whether p is kept in the feed. If classesKnown is false,
+// rules which match Wikidata classes are ignored.
+func (f *Filter) Check(p Page, classesKnown bool) FilterDecision {
+	for _, r := range f.rules {
+		if r.matches(p, classesKnown) {
+			return FilterDecision{Allowed: r.Action == Allow, Rule: r.Name}
+		}
+	}
+	return FilterDecision{Allowed: true}
+}
+
+func (r *compiledRule) matches(p Page, classesKnown bool) bool {
+	if r.projects != nil && !r.projects[p.Project] {
+		return false
+	}
+	if r.MainPage && !p.MainPage {
+		return false
+	}
+	if r.regex != nil && !r.regex.MatchString(p.Title) {
+		return false
+	}
+	if r.titles != nil && !r.titles[normalizeTitle(p.Title)] {
+		return false
+	}
+	if r.namespaces != nil {
+		ns := strings.ToLower(p.Namespace.Canonical)
+		if !r.namespaces[ns] && !(r.namespaces["*"] && p.Namespace.ID != MainNamespace) {
+			return false
+		}
+	}
+	if r.classes != nil {
+		if !classesKnown {
+			return false
+		}
+		var found bool
+		for _, c := range p.Classes {
+			if r.classes[c] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// WithFilter configures the Filter used to remove pages from the top
+// articles and to check articles.
+func WithFilter(f *Filter) Option {
+	return func(c *Client) { c.filter = f }
+}
+
+// Filter returns the Filter of the Client.
+func (c *Client) Filter() *Filter {
+	return c.filter
+}
+
+// Page returns the Page with title in the project described by s.
+func (s *SiteInfo) Page(project, title string) Page {
+	return Page{
+		Project:   project,
+		Title:     title,
+		Namespace: s.Namespace(title),
+		MainPage:  s.IsMainPage(title),
+	}
+}
+
+// siteInfo returns the SiteInfo of project, or nil if it cannot be
+// retrieved, in which case titles are resolved with the canonical namespaces.
+func (c *Client) siteInfo(ctx context.Context, project string) *SiteInfo {
+	info, err := c.GetSiteInfo(ctx, project)
+	if err != nil {
+		log.Printf("filtering %s with canonical namespaces: %v", project, err)
+		return nil
+	}
+	return info
+}
+
+// CheckTitle decides whether the page with title in project is kept in the
+// feed without consulting Wikidata.
+func (c *Client) CheckTitle(ctx context.Context, project, title string) FilterDecision {
+	return c.filter.Check(c.siteInfo(ctx, project).Page(project, title), false)
+}
+
+// CheckArticle decides whether a is kept in the feed. The Wikidata classes of
+// the article are retrieved if the Filter has rules which match them.
+func (c *Client) CheckArticle(ctx context.Context, project string, a *Article) (FilterDecision, error) {
+	p := c.siteInfo(ctx, project).Page(project, a.Article)
+	if c.filter.NeedsClasses() && a.Summary.WikibaseItem != "" {
+		var err error
+		if p.Classes, err = c.GetWikidataClasses(ctx, a.Summary.WikibaseItem); err != nil {
+			return FilterDecision{}, err
+		}
+	}
+	return c.filter.Check(p, true), nil
+}
+
+// filterTopArticles removes the articles of top which are not kept by the
+// Filter of the client without consulting Wikidata.
+func (c *Client) filterTopArticles(
+	ctx context.Context, project string, top []TopPageviewsArticle,
+) (kept, filtered []TopPageviewsArticle) {
+	info := c.siteInfo(ctx, project)
+	kept = top[:0]
+	for _, a := range top {
+		if d := c.filter.Check(info.Page(project, a.Article), false); d.Allowed {
+			kept = append(kept, a)
+		} else {
+			a.FilterRule = d.Rule
+			filtered = append(filtered, a)
+		}
+	}
+	return kept, filtered
+}
@@ -0,0 +1,244 @@
+package wikipedia
+
+import (
+	"context"
+	"html"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ImagePolicy decides which image of an article is shown in the feed.
+type ImagePolicy struct {
+	// MinWidth and MinHeight are the minimum dimensions of the original
+	// image.
+	MinWidth  int
+	MinHeight int
+	// MinAspect and MaxAspect bound the ratio of the width of the image to
+	// its height. Zero means no bound.
+	MinAspect float64
+	MaxAspect float64
+	// MaxSection is the last section of the article from which an image may
+	// be chosen. Negative means any section.
+	MaxSection int
+	// ThumbnailWidth is the width of the thumbnail which is requested.
+	ThumbnailWidth int
+	// MaxCandidates bounds the number of images whose metadata is retrieved
+	// per article.
+	MaxCandidates int
+}
+
+// DefaultImagePolicy is the ImagePolicy of a Client unless configured
+// otherwise.
+var DefaultImagePolicy = ImagePolicy{
+	MinWidth:       320,
+	MinHeight:      200,
+	MinAspect:      0.5,
+	MaxAspect:      2.5,
+	MaxSection:     -1,
+	ThumbnailWidth: 640,
+	MaxCandidates:  3,
+}
+
+// WithImagePolicy configures the policy used to select the image of
+// articles.
+func WithImagePolicy(p ImagePolicy) Option {
+	return func(c *Client) { c.imagePolicy = p }
+}
+
+// decorativeImage matches the titles of files which illustrate many articles
+// rather than their subject, such as flags and icons.
+var decorativeImage = regexp.MustCompile(
+	`(?i)(^|[:_ ])(flag[_ ]of|icon|coat[_ ]of[_ ]arms|logo[_ ]of)([_ .-]|$)`)
+
+// FileTitle returns the title of the file page of m, e.g. "File:Example.jpg".
+func (m *ArticleMediaItem) FileTitle() string {
+	title := m.Title
+	if title == "" {
+		title = m.Titles.Canonical
+	}
+	if title != "" && !strings.Contains(title, ":") {
+		title = "File:" + title
+	}
+	return title
+}
+
+func isSVG(mime, title string) bool {
+	return mime == "image/svg+xml" || strings.HasSuffix(strings.ToLower(title), ".svg")
+}
+
+// fits returns true if an image of the given dimensions satisfies p. Unknown
+// dimensions satisfy any policy.
+func (p *ImagePolicy) fits(width, height int) bool {
+	if width <= 0 || height <= 0 {
+		return true
+	}
+	aspect := float64(width) / float64(height)
+	return width >= p.MinWidth && height >= p.MinHeight &&
+		(p.MinAspect <= 0 || aspect >= p.MinAspect) &&
+		(p.MaxAspect <= 0 || aspect <= p.MaxAspect)
+}
+
+// Candidates returns the images of media which may satisfy p in order of
+// preference: the lead image first and then by section.
+func (p *ImagePolicy) Candidates(media []ArticleMediaItem) []ArticleMediaItem {
+	var candidates []ArticleMediaItem
+	for _, m := range media {
+		title := m.FileTitle()
+		if m.Type != "image" || title == "" ||
+			(p.MaxSection >= 0 && m.SectionID > p.MaxSection) ||
+			isSVG(m.Original.Mime, title) || decorativeImage.MatchString(title) ||
+			!p.fits(m.Original.Width, m.Original.Height) {
+			continue
+		}
+		candidates = append(candidates, m)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].LeadImage != candidates[j].LeadImage {
+			return candidates[i].LeadImage
+		}
+		return candidates[i].SectionID < candidates[j].SectionID
+	})
+	return candidates
+}
+
+// Accepts returns true if the image described by info satisfies p.
+func (p *ImagePolicy) Accepts(info *ImageInfo) bool {
+	return info.URL != "" && info.Width > 0 && info.Height > 0 &&
+		!isSVG(info.Mime, info.Title) && p.fits(info.Width, info.Height)
+}
+
+// ImageInfo describes an image file along with a thumbnail and the terms
+// under which it may be shown.
+type ImageInfo struct {
+	// Title is the title of the file page, e.g. "File:Example.jpg".
+	Title  string
+	URL    string
+	Width  int
+	Height int
+	Mime   string
+
+	ThumbnailURL    string
+	ThumbnailWidth  int
+	ThumbnailHeight int
+
+	// DescriptionURL is the URL of the file page, which credits the image.
+	DescriptionURL string
+	// License is the short name of the license of the image, e.g.
+	// "CC BY-SA 4.0".
+	License    string
+	LicenseURL string
+	// Artist is the author of the image as plain text.
+	Artist              string
+	AttributionRequired bool
+}
+
+// htmlTag matches the tags of the HTML of image metadata.
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// plainText converts HTML to plain text.
+func plainText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(htmlTag.ReplaceAllString(s, ""))), " ")
+}
+
+// GetImageInfo retrieves the metadata of the file with title, e.g.
+// "File:Example.jpg", from project along with a thumbnail of the given
+// width. It returns nil if the file has no image info, e.g. because it does
+// not exist.
+func (c *Client) GetImageInfo(
+	ctx context.Context, project, title string, thumbnailWidth int,
+) (*ImageInfo, error) {
+	type metadata struct {
+		Value string `json:"value"`
+	}
+	var resp struct {
+		Query struct {
+			Pages []struct {
+				Title     string `json:"title"`
+				Missing   bool   `json:"missing"`
+				ImageInfo []struct {
+					URL            string `json:"url"`
+					Width          int    `json:"width"`
+					Height         int    `json:"height"`
+					Mime           string `json:"mime"`
+					ThumbURL       string `json:"thumburl"`
+					ThumbWidth     int    `json:"thumbwidth"`
+					ThumbHeight    int    `json:"thumbheight"`
+					DescriptionURL string `json:"descriptionurl"`
+					ExtMetadata    struct {
+						LicenseShortName    metadata `json:"LicenseShortName"`
+						LicenseURL          metadata `json:"LicenseUrl"`
+						Artist              metadata `json:"Artist"`
+						AttributionRequired metadata `json:"AttributionRequired"`
+					} `json:"extmetadata"`
+				} `json:"imageinfo"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	if err := c.getJSON(ctx, c.projectLimiter(project), c.actionURL(project)+
+		"?action=query&format=json&formatversion=2&prop=imageinfo"+
+		"&iiprop=url|size|mime|extmetadata"+
+		"&iiextmetadatafilter=LicenseShortName|LicenseUrl|Artist|AttributionRequired"+
+		"&iiurlwidth="+strconv.Itoa(thumbnailWidth)+
+		"&titles="+url.QueryEscape(title),
+		&resp); err != nil {
+		return nil, errors.Wrapf(err, "failed to retrieve image info of %s", title)
+	}
+	if len(resp.Query.Pages) == 0 || len(resp.Query.Pages[0].ImageInfo) == 0 {
+		return nil, nil
+	}
+	page := resp.Query.Pages[0]
+	ii := page.ImageInfo[0]
+	return &ImageInfo{
+		Title:               page.Title,
+		URL:                 ii.URL,
+		Width:               ii.Width,
+		Height:              ii.Height,
+		Mime:                ii.Mime,
+		ThumbnailURL:        ii.ThumbURL,
+		ThumbnailWidth:      ii.ThumbWidth,
+		ThumbnailHeight:     ii.ThumbHeight,
+		DescriptionURL:      ii.DescriptionURL,
+		License:             plainText(ii.ExtMetadata.LicenseShortName.Value),
+		LicenseURL:          ii.ExtMetadata.LicenseURL.Value,
+		Artist:              plainText(ii.ExtMetadata.Artist.Value),
+		AttributionRequired: ii.ExtMetadata.AttributionRequired.Value == "true",
+	}, nil
+}
+
+// SelectImage chooses the image of a according to the ImagePolicy of the
+// Client. It returns nil if no image of a satisfies the policy. Candidates
+// whose image info cannot be retrieved are skipped; an error is returned only
+// if the image info of every candidate could not be retrieved.
+func (c *Client) SelectImage(ctx context.Context, project string, a *Article) (*ImageInfo, error) {
+	p := &c.imagePolicy
+	var tried, failed int
+	var lastErr error
+	for i, m := range p.Candidates(a.Media) {
+		if p.MaxCandidates > 0 && i >= p.MaxCandidates {
+			break
+		}
+		tried++
+		info, err := c.GetImageInfo(ctx, project, m.FileTitle(), p.ThumbnailWidth)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			log.Printf("skipping image of %s: %v", a.Article, err)
+			failed, lastErr = failed+1, err
+			continue
+		}
+		if info != nil && p.Accepts(info) {
+			return info, nil
+		}
+	}
+	if tried > 0 && failed == tried {
+		return nil, lastErr
+	}
+	return nil, nil
+}
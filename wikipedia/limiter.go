@@ -0,0 +1,190 @@
+package wikipedia
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// LimiterConfig configures an AdaptiveLimiter.
+type LimiterConfig struct {
+	// Max is the rate at which the limiter starts and to which it recovers.
+	Max rate.Limit
+	// Min is the rate below which the limiter will not back off.
+	Min rate.Limit
+	// Burst is the burst size of the limiter.
+	Burst int
+	// Backoff is the factor by which the rate is multiplied when the server
+	// signals that it is overloaded.
+	Backoff float64
+	// Recovery is the rate, in requests per second per second, at which the
+	// limit grows back towards Max after successful requests.
+	Recovery rate.Limit
+	// LatencyThreshold is the response latency above which a request is
+	// considered a sign of overload. Zero disables latency based backoff.
+	LatencyThreshold time.Duration
+	// Cooldown is the minimum time between consecutive backoffs so that a
+	// burst of concurrent failures only reduces the rate once.
+	Cooldown time.Duration
+}
+
+// DefaultProjectLimiterConfig is the default configuration of the limiter
+// for the REST API of each project.
+var DefaultProjectLimiterConfig = LimiterConfig{
+	Max:              75,
+	Min:              1,
+	Burst:            5,
+	Backoff:          0.5,
+	Recovery:         1,
+	LatencyThreshold: 5 * time.Second,
+	Cooldown:         time.Second,
+}
+
+// DefaultPageviewsLimiterConfig is the default configuration of the limiter
+// for the pageviews API.
+var DefaultPageviewsLimiterConfig = LimiterConfig{
+	Max:              50,
+	Min:              1,
+	Burst:            5,
+	Backoff:          0.5,
+	Recovery:         1,
+	LatencyThreshold: 10 * time.Second,
+	Cooldown:         time.Second,
+}
+
+// DefaultGlobalLimit and DefaultGlobalBurst bound the rate of all requests
+// of a Client regardless of the API or project to which they are sent.
+const (
+	DefaultGlobalLimit rate.Limit = 75
+	DefaultGlobalBurst            = 5
+)
+
+// AdaptiveLimiter is a rate limiter which backs off multiplicatively when the
+// server signals overload and recovers linearly afterwards.
+type AdaptiveLimiter struct {
+	cfg     LimiterConfig
+	limiter *rate.Limiter
+	now     func() time.Time
+
+	mu struct {
+		sync.Mutex
+		limit       rate.Limit
+		lastBackoff time.Time
+		lastUpdate  time.Time
+	}
+}
+
+// NewAdaptiveLimiter creates a new AdaptiveLimiter.
+func NewAdaptiveLimiter(cfg LimiterConfig) *AdaptiveLimiter {
+	l := &AdaptiveLimiter{
+		cfg:     cfg,
+		limiter: rate.NewLimiter(cfg.Max, cfg.Burst),
+		now:     time.Now,
+	}
+	l.mu.limit = cfg.Max
+	return l
+}
+
+// Wait blocks until a request may be issued.
+func (l *AdaptiveLimiter) Wait(ctx context.Context) error {
+	return l.limiter.Wait(ctx)
+}
+
+// Limit returns the current effective rate.
+func (l *AdaptiveLimiter) Limit() rate.Limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.mu.limit
+}
+
+// Throttled informs the limiter that the server rejected a request due to
+// overload.
+func (l *AdaptiveLimiter) Throttled() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.backoffLocked()
+}
+
+// Succeeded informs the limiter that a request succeeded after latency.
+func (l *AdaptiveLimiter) Succeeded(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg.LatencyThreshold > 0 && latency > l.cfg.LatencyThreshold {
+		l.backoffLocked()
+		return
+	}
+	now := l.now()
+	if l.mu.limit < l.cfg.Max && !l.mu.lastUpdate.IsZero() {
+		recovered := l.cfg.Recovery * rate.Limit(now.Sub(l.mu.lastUpdate).Seconds())
+		l.setLimitLocked(l.mu.limit + recovered)
+	}
+	l.mu.lastUpdate = now
+}
+
+func (l *AdaptiveLimiter) backoffLocked() {
+	now := l.now()
+	l.mu.lastUpdate = now
+	if now.Sub(l.mu.lastBackoff) < l.cfg.Cooldown {
+		return
+	}
+	l.mu.lastBackoff = now
+	l.setLimitLocked(l.mu.limit * rate.Limit(l.cfg.Backoff))
+}
+
+func (l *AdaptiveLimiter) setLimitLocked(limit rate.Limit) {
+	if limit > l.cfg.Max {
+		limit = l.cfg.Max
+	}
+	if limit < l.cfg.Min {
+		limit = l.cfg.Min
+	}
+	l.mu.limit = limit
+	l.limiter.SetLimitAt(l.now(), limit)
+}
+
+// WithPageviewsLimiterConfig configures the limiter for the pageviews API.
+func WithPageviewsLimiterConfig(cfg LimiterConfig) Option {
+	return func(c *Client) { c.pageviewsLimiterConfig = cfg }
+}
+
+// WithProjectLimiterConfig configures the limiter for the REST API of each
+// project. Each project has its own limiter, and requests to every project
+// are also bounded by the global limit of the Client.
+func WithProjectLimiterConfig(cfg LimiterConfig) Option {
+	return func(c *Client) { c.projectLimiterConfig = cfg }
+}
+
+// WithGlobalLimit bounds the rate of all requests of the Client, which each
+// also wait on the limiter of their API or project.
+func WithGlobalLimit(limit rate.Limit, burst int) Option {
+	return func(c *Client) {
+		c.globalLimit = limit
+		c.globalBurst = burst
+	}
+}
+
+// PageviewsRate returns the current effective rate of requests to the
+// pageviews API.
+func (c *Client) PageviewsRate() rate.Limit {
+	return c.pageviewsLimiter.Limit()
+}
+
+// ProjectRate returns the current effective rate of requests to the REST API
+// of project.
+func (c *Client) ProjectRate(project string) rate.Limit {
+	return c.projectLimiter(project).Limit()
+}
+
+// projectLimiter returns the limiter of project, creating it if necessary.
+func (c *Client) projectLimiter(project string) *AdaptiveLimiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.mu.projectLimiters[project]
+	if !ok {
+		l = NewAdaptiveLimiter(c.projectLimiterConfig)
+		c.mu.projectLimiters[project] = l
+	}
+	return l
+}
@@ -0,0 +1,71 @@
+package wikipedia
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	l := NewAdaptiveLimiter(LimiterConfig{
+		Max:              100,
+		Min:              10,
+		Burst:            1,
+		Backoff:          0.5,
+		Recovery:         5,
+		LatencyThreshold: time.Second,
+		Cooldown:         time.Second,
+	})
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	assert.Equal(t, rate.Limit(100), l.Limit())
+
+	// Concurrent throttling within the cooldown only backs off once.
+	l.Throttled()
+	l.Throttled()
+	assert.Equal(t, rate.Limit(50), l.Limit())
+
+	// Slow responses back off too.
+	now = now.Add(time.Second)
+	l.Succeeded(2 * time.Second)
+	assert.Equal(t, rate.Limit(25), l.Limit())
+
+	// Backoff is bounded below by Min.
+	for i := 0; i < 5; i++ {
+		now = now.Add(time.Second)
+		l.Throttled()
+	}
+	assert.Equal(t, rate.Limit(10), l.Limit())
+
+	// Successes recover linearly to Max.
+	now = now.Add(2 * time.Second)
+	l.Succeeded(time.Millisecond)
+	assert.Equal(t, rate.Limit(20), l.Limit())
+	now = now.Add(time.Minute)
+	l.Succeeded(time.Millisecond)
+	assert.Equal(t, rate.Limit(100), l.Limit())
+}
+
+func TestGlobalLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	c := New(WithHTTPClient(srv.Client()), WithGlobalLimit(20, 1))
+	// Requests to different projects are bounded by the global limit even
+	// though each project has its own limiter.
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		project := []string{"en", "fr"}[i%2]
+		var v struct{}
+		require.Nil(t, c.getJSON(ctx, c.projectLimiter(project), srv.URL, &v))
+	}
+	assert.True(t, time.Since(start) >= 400*time.Millisecond, "took %v", time.Since(start))
+}
@@ -0,0 +1,377 @@
+package wikipedia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSitematrixURL is the URL of the action API which serves the
+// sitematrix of all wikimedia projects.
+const DefaultSitematrixURL = "https://meta.wikimedia.org/w/api.php"
+
+// Family is a family of wikimedia sites which exist in many languages.
+type Family string
+
+// Family values supported by the client.
+const (
+	Wikipedia  Family = "wikipedia"
+	Wiktionary Family = "wiktionary"
+	Wikinews   Family = "wikinews"
+	Wikivoyage Family = "wikivoyage"
+)
+
+// Families lists every Family.
+var Families = []Family{Wikipedia, Wiktionary, Wikinews, Wikivoyage}
+
+// ParseFamily parses a Family.
+func ParseFamily(s string) (Family, error) {
+	for _, f := range Families {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown family %q", s)
+}
+
+// ProjectCode returns the code of the project of family f in language.
+// Wikipedia projects are identified by their language alone and other
+// projects as <language>.<family>, e.g. "en.wiktionary".
+func ProjectCode(f Family, language string) string {
+	if f == Wikipedia {
+		return language
+	}
+	return language + "." + string(f)
+}
+
+// SplitProject returns the family and language of the project identified by
+// code. It is the inverse of ProjectCode.
+func SplitProject(code string) (f Family, language string) {
+	if i := strings.LastIndex(code, "."); i >= 0 {
+		return Family(code[i+1:]), code[:i]
+	}
+	return Wikipedia, code
+}
+
+// ProjectDomain returns the domain of the site of project, e.g.
+// "en.wiktionary.org" for "en.wiktionary".
+func ProjectDomain(project string) string {
+	f, language := SplitProject(project)
+	return language + "." + string(f) + ".org"
+}
+
+// ProjectForDomain returns the code of the project whose site is at domain.
+// It is the inverse of ProjectDomain.
+func ProjectForDomain(domain string) (project string, ok bool) {
+	parts := strings.Split(domain, ".")
+	if len(parts) != 3 || parts[2] != "org" {
+		return "", false
+	}
+	return ProjectCode(Family(parts[1]), parts[0]), true
+}
+
+// ProjectInfo describes a project, which is a site of a Family in a
+// language.
+type ProjectInfo struct {
+	// Code identifies the project as described by ProjectCode.
+	Code     string `json:"code"`
+	Family   Family `json:"family"`
+	Language string `json:"language"`
+	// Name is the name of the language of the project in English.
+	Name string `json:"name"`
+	// LocalName is the name of the language of the project in that language.
+	LocalName string `json:"local_name"`
+	// Direction is the direction of the text of the project, "ltr" or "rtl".
+	Direction string `json:"direction"`
+	// Articles is the number of articles in the project, or zero if unknown.
+	Articles int `json:"articles"`
+	// URL is the base URL of the site of the project.
+	URL string `json:"url"`
+}
+
+// DefaultProjects are the projects of the Registry used by a Client unless
+// configured otherwise.
+var DefaultProjects = []ProjectInfo{
+	defaultProject("en", "English", "English", "ltr"),
+	defaultProject("fr", "French", "Français", "ltr"),
+	defaultProject("es", "Spanish", "Español", "ltr"),
+	defaultProject("de", "German", "Deutsch", "ltr"),
+	defaultProject("ru", "Russian", "Русский", "ltr"),
+	defaultProject("ja", "Japanese", "日本語", "ltr"),
+	defaultProject("nl", "Dutch", "Nederlands", "ltr"),
+	defaultProject("it", "Italian", "Italiano", "ltr"),
+	defaultProject("sv", "Swedish", "Svenska", "ltr"),
+	defaultProject("pl", "Polish", "Polski", "ltr"),
+	defaultProject("vi", "Vietnamese", "Tiếng Việt", "ltr"),
+	defaultProject("pt", "Portuguese", "Português", "ltr"),
+	defaultProject("ar", "Arabic", "العربية", "rtl"),
+	defaultProject("zh", "Chinese", "中文", "ltr"),
+	defaultProject("uk", "Ukrainian", "Українська", "ltr"),
+	defaultProject("ro", "Romanian", "Română", "ltr"),
+	defaultProject("bg", "Bulgarian", "Български", "ltr"),
+	defaultProject("th", "Thai", "ไทย", "ltr"),
+	defaultProject("fa", "Persian", "فارسی", "rtl"),
+}
+
+func defaultProject(code, name, localName, dir string) ProjectInfo {
+	return ProjectInfo{
+		Code:      code,
+		Family:    Wikipedia,
+		Language:  code,
+		Name:      name,
+		LocalName: localName,
+		Direction: dir,
+		URL:       "https://" + ProjectDomain(code),
+	}
+}
+
+// Registry is an immutable, ordered set of projects.
+type Registry struct {
+	projects []ProjectInfo
+	byCode   map[string]int
+}
+
+// NewRegistry creates a Registry of projects in the given order. Later
+// duplicates of a project are ignored.
+func NewRegistry(projects []ProjectInfo) *Registry {
+	r := &Registry{byCode: make(map[string]int, len(projects))}
+	for _, p := range projects {
+		if _, ok := r.byCode[p.Code]; ok {
+			continue
+		}
+		r.byCode[p.Code] = len(r.projects)
+		r.projects = append(r.projects, p)
+	}
+	return r
+}
+
+// DefaultRegistry returns a Registry of DefaultProjects.
+func DefaultRegistry() *Registry {
+	return NewRegistry(DefaultProjects)
+}
+
+// LoadRegistry reads a Registry from a file containing a JSON array of
+// ProjectInfo. The family and language of each project default to those
+// implied by its code.
+func LoadRegistry(path string) (*Registry, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var projects []ProjectInfo
+	if err := json.Unmarshal(buf, &projects); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse projects from %s", path)
+	}
+	for i := range projects {
+		p := &projects[i]
+		if p.Code == "" {
+			return nil, fmt.Errorf("project %d in %s has no code", i, path)
+		}
+		f, language := SplitProject(p.Code)
+		if p.Family == "" {
+			p.Family = f
+		}
+		if p.Language == "" {
+			p.Language = language
+		}
+		if _, err := ParseFamily(string(p.Family)); err != nil {
+			return nil, errors.Wrapf(err, "invalid project %s in %s", p.Code, path)
+		}
+	}
+	return NewRegistry(projects), nil
+}
+
+// Has returns true if project is in r.
+func (r *Registry) Has(project string) bool {
+	_, ok := r.byCode[project]
+	return ok
+}
+
+// Lookup returns the ProjectInfo of project.
+func (r *Registry) Lookup(project string) (ProjectInfo, bool) {
+	i, ok := r.byCode[project]
+	if !ok {
+		return ProjectInfo{}, false
+	}
+	return r.projects[i], true
+}
+
+// Projects returns the projects of r in order.
+func (r *Registry) Projects() []ProjectInfo {
+	return append([]ProjectInfo(nil), r.projects...)
+}
+
+// Codes returns the codes of the projects of r in order.
+func (r *Registry) Codes() []string {
+	codes := make([]string, len(r.projects))
+	for i, p := range r.projects {
+		codes[i] = p.Code
+	}
+	return codes
+}
+
+// WithProjects configures the set of projects which the Client may access.
+func WithProjects(r *Registry) Option {
+	return func(c *Client) { c.projects = r }
+}
+
+// WithSitematrixURL overrides the URL of the action API which serves the
+// sitematrix.
+func WithSitematrixURL(url string) Option {
+	return func(c *Client) { c.sitematrixURL = url }
+}
+
+// Projects returns the set of projects which the Client may access.
+func (c *Client) Projects() *Registry {
+	return c.projects
+}
+
+// sitematrixLanguage is a language entry in the sitematrix response.
+type sitematrixLanguage struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	LocalName string `json:"localname"`
+	Dir       string `json:"dir"`
+	Site      []struct {
+		URL  string `json:"url"`
+		Code string `json:"code"`
+		// Closed, Fishbowl and Private are present only for sites which
+		// are not open to the public.
+		Closed   *string `json:"closed"`
+		Fishbowl *string `json:"fishbowl"`
+		Private  *string `json:"private"`
+	} `json:"site"`
+}
+
+// sitematrixFamily returns the Family of a site in the sitematrix.
+func sitematrixFamily(siteCode string) (Family, bool) {
+	if siteCode == "wiki" {
+		return Wikipedia, true
+	}
+	f, err := ParseFamily(siteCode)
+	return f, err == nil
+}
+
+// DiscoverProjects retrieves the open projects of families from the
+// sitematrix along with the number of articles in each. If no families are
+// specified, only Wikipedia projects are discovered. Projects with fewer than
+// minArticles articles are omitted unless their number of articles could not
+// be retrieved. The returned Registry is ordered by
+// descending number of articles.
+func (c *Client) DiscoverProjects(
+	ctx context.Context, minArticles int, families ...Family,
+) (*Registry, error) {
+	if len(families) == 0 {
+		families = []Family{Wikipedia}
+	}
+	wanted := make(map[Family]bool, len(families))
+	for _, f := range families {
+		wanted[f] = true
+	}
+	var resp struct {
+		Sitematrix map[string]json.RawMessage `json:"sitematrix"`
+	}
+	// The sitematrix is served by meta.wikimedia.org which is limited like
+	// any other project.
+	if err := c.getJSON(ctx, c.projectLimiter("meta"),
+		c.sitematrixURL+"?action=sitematrix&format=json&smtype=language", &resp); err != nil {
+		return nil, err
+	}
+	var projects []ProjectInfo
+	for key, raw := range resp.Sitematrix {
+		// Languages are keyed by index alongside metadata such as "count".
+		if key == "count" || key == "specials" {
+			continue
+		}
+		var lang sitematrixLanguage
+		if err := json.Unmarshal(raw, &lang); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode sitematrix entry %s", key)
+		}
+		for _, site := range lang.Site {
+			f, ok := sitematrixFamily(site.Code)
+			if !ok || !wanted[f] ||
+				site.Closed != nil || site.Fishbowl != nil || site.Private != nil {
+				continue
+			}
+			projects = append(projects, ProjectInfo{
+				Code:      ProjectCode(f, lang.Code),
+				Family:    f,
+				Language:  lang.Code,
+				Name:      lang.LocalName,
+				LocalName: lang.Name,
+				Direction: lang.Dir,
+				URL:       strings.TrimSuffix(site.URL, "/"),
+			})
+		}
+	}
+	unknown, err := c.fetchArticleCounts(ctx, projects)
+	if err != nil {
+		return nil, err
+	}
+	kept := projects[:0]
+	for i, p := range projects {
+		if p.Articles >= minArticles || unknown[i] {
+			kept = append(kept, p)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		if kept[i].Articles != kept[j].Articles {
+			return kept[i].Articles > kept[j].Articles
+		}
+		return kept[i].Code < kept[j].Code
+	})
+	return NewRegistry(kept), nil
+}
+
+// fetchArticleCounts populates the Articles field of each project using the
+// siteinfo statistics of its action API. The count of a project whose
+// statistics cannot be retrieved is left unknown, as indicated by the
+// corresponding element of the returned slice, so that one unavailable wiki
+// does not prevent the others from being discovered.
+func (c *Client) fetchArticleCounts(ctx context.Context, projects []ProjectInfo) (unknown []bool, _ error) {
+	const concurrency = 8
+	sem := make(chan struct{}, concurrency)
+	unknown = make([]bool, len(projects))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := range projects {
+		i, p := i, &projects[i]
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var resp struct {
+				Query struct {
+					Statistics struct {
+						Articles int `json:"articles"`
+					} `json:"statistics"`
+				} `json:"query"`
+			}
+			if err := c.getJSON(ctx, c.projectLimiter(p.Code),
+				c.actionURL(p.Code)+"?action=query&meta=siteinfo&siprop=statistics&format=json",
+				&resp); err != nil {
+				log.Printf("failed to retrieve statistics of %s: %v", p.Code, err)
+				unknown[i] = true
+				return
+			}
+			p.Articles = resp.Query.Statistics.Articles
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return unknown, nil
+}
@@ -0,0 +1,140 @@
+package wikipedia
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotRecorded is the cause of errors returned by a ReplayTransport for
+// requests which were not recorded.
+var ErrNotRecorded = errors.New("no recorded response")
+
+// IsNotRecorded returns true if err was caused by a request which a
+// ReplayTransport could not serve.
+func IsNotRecorded(err error) bool {
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	return errors.Cause(err) == ErrNotRecorded
+}
+
+// exchange is the representation of a recorded request and its response on
+// disk.
+type exchange struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	IfNoneMatch string      `json:"if_none_match,omitempty"`
+	StatusCode  int         `json:"status_code"`
+	Header      http.Header `json:"header"`
+	Body        string      `json:"body"`
+}
+
+// exchangeFile returns the name of the file which records the response to
+// req. Conditional requests are recorded separately from unconditional ones.
+func exchangeFile(dir string, req *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.String() + "\n" + req.Header.Get("If-None-Match")))
+	return filepath.Join(dir, hex.EncodeToString(h.Sum(nil))+".json")
+}
+
+// RecordingTransport is an http.RoundTripper which records each request and
+// its response to a directory from which a ReplayTransport can serve them.
+// If a request is made more than once, the last response is recorded.
+type RecordingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+// NewRecordingTransport creates a RecordingTransport which records the
+// exchanges made through next in dir, creating it if necessary. If next is
+// nil, http.DefaultTransport is used.
+func NewRecordingTransport(dir string, next http.RoundTripper) (*RecordingTransport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create recording directory")
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &RecordingTransport{dir: dir, next: next}, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := json.MarshalIndent(exchange{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		IfNoneMatch: req.Header.Get("If-None-Match"),
+		StatusCode:  resp.StatusCode,
+		Header:      resp.Header,
+		Body:        string(body),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(exchangeFile(t.dir, req), buf); err != nil {
+		return nil, errors.Wrapf(err, "failed to record response from %s", req.URL)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// ReplayTransport is an http.RoundTripper which serves the responses recorded
+// by a RecordingTransport. Requests which were not recorded fail with an
+// error caused by ErrNotRecorded.
+type ReplayTransport struct {
+	dir string
+}
+
+// NewReplayTransport creates a ReplayTransport which serves the exchanges
+// recorded in dir.
+func NewReplayTransport(dir string) (*ReplayTransport, error) {
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, errors.Wrap(err, "failed to open recording directory")
+	} else if !fi.IsDir() {
+		return nil, errors.Errorf("%s is not a directory", dir)
+	}
+	return &ReplayTransport{dir: dir}, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	buf, err := ioutil.ReadFile(exchangeFile(t.dir, req))
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(ErrNotRecorded, "%s %s", req.Method, req.URL)
+	} else if err != nil {
+		return nil, err
+	}
+	var e exchange
+	if err := json.Unmarshal(buf, &e); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode recorded response for %s", req.URL)
+	}
+	return &http.Response{
+		Status:        http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(e.Body))),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,238 @@
+package wikipedia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StatusError is returned when the API responds with a status other than
+// 200 OK.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	// RetryAfter is the delay requested by the server via the Retry-After
+	// header, if any.
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d from %s: resp %s", e.StatusCode, e.URL, e.Body)
+}
+
+// Temporary returns true if the request which led to e may succeed if
+// retried.
+func (e *StatusError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsNotFound returns true if err was caused by a 404 response.
+func IsNotFound(err error) bool {
+	se, ok := errors.Cause(err).(*StatusError)
+	return ok && se.StatusCode == http.StatusNotFound
+}
+
+// IsThrottled returns true if err was caused by a 429 response.
+func IsThrottled(err error) bool {
+	se, ok := errors.Cause(err).(*StatusError)
+	return ok && se.StatusCode == http.StatusTooManyRequests
+}
+
+// RetryPolicy controls how a Client retries requests which fail transiently.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts for a request including
+	// the first. Values less than 1 are treated as 1.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff bounds the delay between attempts which is not requested
+	// by the server.
+	MaxBackoff time.Duration
+	// Multiplier is the factor by which the backoff grows after each attempt.
+	Multiplier float64
+	// Jitter is the fraction of the backoff by which it is randomly perturbed.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by a Client unless configured
+// otherwise.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 250 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// NoRetries is a RetryPolicy which never retries.
+var NoRetries = RetryPolicy{MaxAttempts: 1}
+
+// WithRetryPolicy configures the RetryPolicy of the Client.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// backoff returns the delay before the retry which follows attempt, which is
+// numbered from 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		d *= p.Multiplier
+		if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	d += d * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(d)
+}
+
+// shouldRetry returns true if err is a transient failure.
+func shouldRetry(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || IsNotRecorded(err) {
+		return false
+	}
+	switch err := errors.Cause(err).(type) {
+	case *StatusError:
+		return err.Temporary()
+	case net.Error:
+		// Network errors from the http.Client. Permanent failures such as
+		// refused connections or invalid certificates are not retried.
+		return err.Timeout() || err.Temporary()
+	default:
+		return false
+	}
+}
+
+// getJSON issues a GET request to url and decodes the JSON response into v,
+// retrying transient failures according to the RetryPolicy of the client.
+func (c *Client) getJSON(
+	ctx context.Context, limiter *AdaptiveLimiter, url string, v interface{},
+) error {
+	_, _, err := c.getJSONIfNoneMatch(ctx, limiter, url, "", v)
+	return err
+}
+
+// getJSONIfNoneMatch is like getJSON but, if etag is non-empty, makes the
+// request conditional on the resource not matching etag. If the resource
+// matches, notModified is true and v is left untouched. The ETag of the
+// response is returned.
+func (c *Client) getJSONIfNoneMatch(
+	ctx context.Context, limiter *AdaptiveLimiter, url, etag string, v interface{},
+) (newETag string, notModified bool, _ error) {
+	if c.cache != nil {
+		return c.getJSONCached(ctx, limiter, url, etag, v)
+	}
+	return c.getJSONWithRetries(ctx, limiter, url, etag, v)
+}
+
+// getJSONWithRetries issues a conditional request to the API, retrying
+// transient failures according to the RetryPolicy of the client.
+func (c *Client) getJSONWithRetries(
+	ctx context.Context, limiter *AdaptiveLimiter, url, etag string, v interface{},
+) (newETag string, notModified bool, _ error) {
+	for attempt := 1; ; attempt++ {
+		newETag, notModified, err := c.tryGetJSON(ctx, limiter, url, etag, v)
+		if err == nil || attempt >= c.retry.MaxAttempts || !shouldRetry(ctx, err) {
+			return newETag, notModified, err
+		}
+		wait := c.retry.backoff(attempt)
+		if se, ok := err.(*StatusError); ok && se.RetryAfter > wait {
+			wait = se.RetryAfter
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) tryGetJSON(
+	ctx context.Context, limiter *AdaptiveLimiter, url, etag string, v interface{},
+) (newETag string, notModified bool, _ error) {
+	if err := limiter.Wait(ctx); err != nil {
+		return "", false, err
+	}
+	if err := c.globalLimiter.Wait(ctx); err != nil {
+		return "", false, err
+	}
+	if c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Accept", "application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	start := time.Now()
+	resp, err := c.cli.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		limiter.Throttled()
+	default:
+		limiter.Succeeded(time.Since(start))
+	}
+	newETag = resp.Header.Get("ETag")
+	if etag != "" && resp.StatusCode == http.StatusNotModified {
+		return newETag, true, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return "", false, &StatusError{
+			URL:        url,
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Body:       string(respBody),
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	return newETag, false, errors.Wrapf(err, "failed to decode response from %s", url)
+}
+
+// parseRetryAfter parses the value of a Retry-After header which may either
+// be a number of seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,160 @@
+package wikipedia
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultActionURLFormat is the format string used to construct the URL of
+// the action API of a project from its domain as returned by ProjectDomain.
+const DefaultActionURLFormat = "https://%s/w/api.php"
+
+// WithActionURLFormat overrides the URL of the action API for every project
+// using format, which must contain a single %s for the domain of the project.
+func WithActionURLFormat(format string) Option {
+	return func(c *Client) { c.actionURLFormat = format }
+}
+
+// actionURL returns the URL of the action API of project. Unlike apiURL, it
+// may be used for projects which are not among the projects of the client.
+func (c *Client) actionURL(project string) string {
+	return fmt.Sprintf(c.actionURLFormat, ProjectDomain(project))
+}
+
+// MainNamespace is the ID of the namespace of articles.
+const MainNamespace = 0
+
+// Namespace describes a namespace of a project.
+type Namespace struct {
+	ID int `json:"id"`
+	// Name is the localized name of the namespace.
+	Name string `json:"name"`
+	// Canonical is the English name of the namespace, e.g. "File".
+	Canonical string `json:"canonical"`
+	// Aliases are alternative names of the namespace.
+	Aliases []string `json:"-"`
+}
+
+// SiteInfo describes the configuration of a project.
+type SiteInfo struct {
+	// MainPage is the title of the main page of the project.
+	MainPage   string
+	Namespaces []Namespace
+}
+
+// canonicalNamespaces are the namespaces which exist in every project. They
+// are used to resolve titles when the SiteInfo of a project is unavailable.
+var canonicalNamespaces = []Namespace{
+	{ID: -2, Canonical: "Media"},
+	{ID: -1, Canonical: "Special"},
+	{ID: 1, Canonical: "Talk"},
+	{ID: 2, Canonical: "User"},
+	{ID: 3, Canonical: "User talk"},
+	{ID: 4, Canonical: "Project"},
+	{ID: 5, Canonical: "Project talk"},
+	{ID: 6, Canonical: "File", Aliases: []string{"Image"}},
+	{ID: 7, Canonical: "File talk"},
+	{ID: 8, Canonical: "MediaWiki"},
+	{ID: 9, Canonical: "MediaWiki talk"},
+	{ID: 10, Canonical: "Template"},
+	{ID: 11, Canonical: "Template talk"},
+	{ID: 12, Canonical: "Help"},
+	{ID: 13, Canonical: "Help talk"},
+	{ID: 14, Canonical: "Category"},
+	{ID: 15, Canonical: "Category talk"},
+	{ID: 100, Canonical: "Portal"},
+	{ID: 101, Canonical: "Portal talk"},
+	{ID: 118, Canonical: "Draft"},
+	{ID: 119, Canonical: "Draft talk"},
+	{ID: 828, Canonical: "Module"},
+	{ID: 829, Canonical: "Module talk"},
+}
+
+// normalizeTitle converts the underscores of a title as used in URLs to
+// spaces.
+func normalizeTitle(title string) string {
+	return strings.Replace(title, "_", " ", -1)
+}
+
+// Namespace returns the namespace of title. Titles without a recognized
+// namespace prefix are in the main namespace.
+func (s *SiteInfo) Namespace(title string) Namespace {
+	i := strings.Index(title, ":")
+	if i <= 0 {
+		return Namespace{ID: MainNamespace}
+	}
+	prefix := strings.TrimSpace(normalizeTitle(title[:i]))
+	namespaces := canonicalNamespaces
+	if s != nil && len(s.Namespaces) > 0 {
+		namespaces = s.Namespaces
+	}
+	for _, ns := range namespaces {
+		if ns.ID == MainNamespace {
+			continue
+		}
+		if strings.EqualFold(prefix, ns.Name) || strings.EqualFold(prefix, ns.Canonical) {
+			return ns
+		}
+		for _, alias := range ns.Aliases {
+			if strings.EqualFold(prefix, alias) {
+				return ns
+			}
+		}
+	}
+	return Namespace{ID: MainNamespace}
+}
+
+// IsMainPage returns true if title is the main page of the project.
+func (s *SiteInfo) IsMainPage(title string) bool {
+	mainPage := "Main Page"
+	if s != nil && s.MainPage != "" {
+		mainPage = s.MainPage
+	}
+	return normalizeTitle(title) == normalizeTitle(mainPage)
+}
+
+// GetSiteInfo retrieves the main page and namespaces of project. The result
+// is cached for the lifetime of the Client.
+func (c *Client) GetSiteInfo(ctx context.Context, project string) (*SiteInfo, error) {
+	c.mu.Lock()
+	info, ok := c.mu.siteInfos[project]
+	c.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+	var resp struct {
+		Query struct {
+			General struct {
+				MainPage string `json:"mainpage"`
+			} `json:"general"`
+			Namespaces       map[string]Namespace `json:"namespaces"`
+			NamespaceAliases []struct {
+				ID    int    `json:"id"`
+				Alias string `json:"alias"`
+			} `json:"namespacealiases"`
+		} `json:"query"`
+	}
+	if err := c.getJSON(ctx, c.projectLimiter(project), c.actionURL(project)+
+		"?action=query&meta=siteinfo&siprop=general|namespaces|namespacealiases&format=json&formatversion=2",
+		&resp); err != nil {
+		return nil, errors.Wrapf(err, "failed to retrieve site info of %s", project)
+	}
+	info = &SiteInfo{MainPage: resp.Query.General.MainPage}
+	byID := make(map[int]int, len(resp.Query.Namespaces))
+	for _, ns := range resp.Query.Namespaces {
+		byID[ns.ID] = len(info.Namespaces)
+		info.Namespaces = append(info.Namespaces, ns)
+	}
+	for _, a := range resp.Query.NamespaceAliases {
+		if i, ok := byID[a.ID]; ok {
+			info.Namespaces[i].Aliases = append(info.Namespaces[i].Aliases, a.Alias)
+		}
+	}
+	c.mu.Lock()
+	c.mu.siteInfos[project] = info
+	c.mu.Unlock()
+	return info, nil
+}
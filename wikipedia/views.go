@@ -0,0 +1,66 @@
+package wikipedia
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Granularity is the time granularity of a pageviews series.
+type Granularity string
+
+// Granularity values supported by the per-article pageviews API.
+const (
+	Hourly Granularity = "hourly"
+	Daily  Granularity = "daily"
+)
+
+// ParseGranularity parses a Granularity value.
+func ParseGranularity(s string) (Granularity, error) {
+	switch g := Granularity(s); g {
+	case Hourly, Daily:
+		return g, nil
+	default:
+		return "", fmt.Errorf("unknown granularity %q", s)
+	}
+}
+
+// viewsTimestampFormat is the format of timestamps in the pageviews API.
+const viewsTimestampFormat = "2006010215"
+
+// ArticleViews is an entry in the pageviews series of an article.
+type ArticleViews struct {
+	Project     string `json:"project"`
+	Article     string `json:"article"`
+	Granularity string `json:"granularity"`
+	Timestamp   string `json:"timestamp"`
+	Access      string `json:"access"`
+	Agent       string `json:"agent"`
+	Views       int    `json:"views"`
+}
+
+// Time parses the Timestamp of v.
+func (v ArticleViews) Time() (time.Time, error) {
+	return time.Parse(viewsTimestampFormat, v.Timestamp)
+}
+
+// FetchArticleViews fetches the pageviews series of an article with the
+// given granularity between from and to inclusive. An article without any
+// recorded views yields an empty series.
+func (c *Client) FetchArticleViews(
+	ctx context.Context, project, articleName string, granularity Granularity, from, to time.Time,
+) ([]ArticleViews, error) {
+	viewsURL := fmt.Sprintf(c.pageviewsURL+"/metrics/pageviews/per-article/%s/%s/user/%s/%s/%s/%s",
+		ProjectDomain(project), AllAccess, url.PathEscape(articleName), granularity,
+		from.UTC().Format(viewsTimestampFormat), to.UTC().Format(viewsTimestampFormat))
+	var result struct {
+		Items []ArticleViews `json:"items"`
+	}
+	if err := c.getJSON(ctx, c.pageviewsLimiter, viewsURL, &result); IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return result.Items, nil
+}
@@ -0,0 +1,300 @@
+package wikipedia
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultWikidataURL is the URL of the action API of Wikidata.
+const DefaultWikidataURL = "https://www.wikidata.org/w/api.php"
+
+// Wikidata properties of the facts which are retrieved about entities.
+const (
+	instanceOf  = "P31"
+	image       = "P18"
+	coordinates = "P625"
+)
+
+// DateProperties maps the Wikidata properties of the dates of an entity which
+// are retrieved to the kind of each date.
+var DateProperties = map[string]string{
+	"P569": "birth",
+	"P570": "death",
+	"P571": "inception",
+	"P576": "dissolution",
+	"P577": "publication",
+	"P580": "start",
+	"P582": "end",
+	"P585": "point_in_time",
+}
+
+// maxEntitiesPerRequest is the maximum number of entities which may be
+// requested from wbgetentities at once.
+const maxEntitiesPerRequest = 50
+
+// WithWikidataURL overrides the URL of the action API of Wikidata.
+func WithWikidataURL(url string) Option {
+	return func(c *Client) { c.wikidataURL = url }
+}
+
+// Entity describes the subject of an article using the facts of its Wikidata
+// item.
+type Entity struct {
+	// ID is the Wikidata item, e.g. "Q42".
+	ID          string
+	Description string
+	// Classes are the classes of which the entity is an instance, e.g.
+	// "Q5" (human).
+	Classes     []EntityClass
+	Coordinates *Coordinates
+	Dates       []EntityDate
+	// Image is the name of an image of the entity on Wikimedia Commons.
+	Image string
+}
+
+// EntityClass is a class of which an Entity is an instance.
+type EntityClass struct {
+	ID    string
+	Label string
+}
+
+// Coordinates locates an Entity on Earth.
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// EntityDate is a date of an Entity.
+type EntityDate struct {
+	// Kind is the meaning of the date as given by DateProperties.
+	Kind string
+	// Date is formatted to its precision as YYYY, YYYY-MM or YYYY-MM-DD.
+	// Years before the common era are negative.
+	Date string
+	Year int
+}
+
+// CommonsFileURL returns the URL which serves the file on Wikimedia Commons
+// with the given name.
+func CommonsFileURL(name string) string {
+	return "https://commons.wikimedia.org/wiki/Special:FilePath/" +
+		url.PathEscape(strings.Replace(name, " ", "_", -1))
+}
+
+// wikidataClaim is a statement about an entity as returned by wbgetclaims and
+// wbgetentities.
+type wikidataClaim struct {
+	Rank     string `json:"rank"`
+	MainSnak struct {
+		DataValue struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"datavalue"`
+	} `json:"mainsnak"`
+}
+
+// bestClaims returns the claims of the best rank, ignoring deprecated ones.
+func bestClaims(claims []wikidataClaim) []wikidataClaim {
+	var preferred, normal []wikidataClaim
+	for _, c := range claims {
+		switch {
+		case len(c.MainSnak.DataValue.Value) == 0:
+			// The claim has no value or an unknown value.
+		case c.Rank == "preferred":
+			preferred = append(preferred, c)
+		case c.Rank != "deprecated":
+			normal = append(normal, c)
+		}
+	}
+	if len(preferred) > 0 {
+		return preferred
+	}
+	return normal
+}
+
+// claimIDs returns the items which are the values of claims.
+func claimIDs(claims []wikidataClaim) []string {
+	var ids []string
+	for _, c := range bestClaims(claims) {
+		var v struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(c.MainSnak.DataValue.Value, &v); err == nil && v.ID != "" {
+			ids = append(ids, v.ID)
+		}
+	}
+	return ids
+}
+
+// GetWikidataClasses retrieves the classes of which the Wikidata item, e.g.
+// "Q42", is an instance.
+func (c *Client) GetWikidataClasses(ctx context.Context, item string) ([]string, error) {
+	var resp struct {
+		Claims map[string][]wikidataClaim `json:"claims"`
+	}
+	if err := c.getJSON(ctx, c.projectLimiter("wikidata"), c.wikidataURL+
+		"?action=wbgetclaims&format=json&property="+instanceOf+"&entity="+url.QueryEscape(item),
+		&resp); err != nil {
+		return nil, errors.Wrapf(err, "failed to retrieve classes of %s", item)
+	}
+	return claimIDs(resp.Claims[instanceOf]), nil
+}
+
+// wikidataEntity is an entity as returned by wbgetentities.
+type wikidataEntity struct {
+	Missing      *string                    `json:"missing"`
+	Labels       map[string]wikidataText    `json:"labels"`
+	Descriptions map[string]wikidataText    `json:"descriptions"`
+	Claims       map[string][]wikidataClaim `json:"claims"`
+}
+
+type wikidataText struct {
+	Value string `json:"value"`
+}
+
+// getWikidataEntities retrieves the given properties of items in language.
+func (c *Client) getWikidataEntities(
+	ctx context.Context, items []string, props, language string,
+) (map[string]wikidataEntity, error) {
+	var resp struct {
+		Entities map[string]wikidataEntity `json:"entities"`
+	}
+	if err := c.getJSON(ctx, c.projectLimiter("wikidata"), c.wikidataURL+
+		"?action=wbgetentities&format=json&languagefallback=1"+
+		"&ids="+url.QueryEscape(strings.Join(items, "|"))+
+		"&props="+url.QueryEscape(props)+
+		"&languages="+url.QueryEscape(language),
+		&resp); err != nil {
+		return nil, err
+	}
+	return resp.Entities, nil
+}
+
+// GetWikidataEntity retrieves the facts about the Wikidata item, e.g. "Q42",
+// with descriptions and class labels in language.
+func (c *Client) GetWikidataEntity(ctx context.Context, item, language string) (*Entity, error) {
+	entities, err := c.getWikidataEntities(ctx, []string{item}, "descriptions|claims", language)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to retrieve entity %s", item)
+	}
+	we, ok := entities[item]
+	if !ok || we.Missing != nil {
+		return nil, errors.Errorf("entity %s does not exist", item)
+	}
+	e := &Entity{ID: item, Description: we.Descriptions[language].Value}
+	classes := claimIDs(we.Claims[instanceOf])
+	labels, err := c.getWikidataLabels(ctx, classes, language)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to retrieve classes of %s", item)
+	}
+	for _, id := range classes {
+		e.Classes = append(e.Classes, EntityClass{ID: id, Label: labels[id]})
+	}
+	for _, claim := range bestClaims(we.Claims[coordinates]) {
+		var v Coordinates
+		if err := json.Unmarshal(claim.MainSnak.DataValue.Value, &v); err == nil {
+			e.Coordinates = &v
+			break
+		}
+	}
+	for _, claim := range bestClaims(we.Claims[image]) {
+		if err := json.Unmarshal(claim.MainSnak.DataValue.Value, &e.Image); err == nil {
+			break
+		}
+	}
+	for _, prop := range sortedKeys(DateProperties) {
+		for _, claim := range bestClaims(we.Claims[prop]) {
+			var v struct {
+				Time      string `json:"time"`
+				Precision int    `json:"precision"`
+			}
+			if err := json.Unmarshal(claim.MainSnak.DataValue.Value, &v); err != nil {
+				continue
+			}
+			if d, ok := parseWikidataTime(v.Time, v.Precision); ok {
+				d.Kind = DateProperties[prop]
+				e.Dates = append(e.Dates, d)
+				break
+			}
+		}
+	}
+	return e, nil
+}
+
+// getWikidataLabels retrieves the labels of items in language.
+func (c *Client) getWikidataLabels(
+	ctx context.Context, items []string, language string,
+) (map[string]string, error) {
+	labels := make(map[string]string, len(items))
+	for len(items) > 0 {
+		batch := items
+		if len(batch) > maxEntitiesPerRequest {
+			batch = batch[:maxEntitiesPerRequest]
+		}
+		items = items[len(batch):]
+		entities, err := c.getWikidataEntities(ctx, batch, "labels", language)
+		if err != nil {
+			return nil, err
+		}
+		for id, e := range entities {
+			labels[id] = e.Labels[language].Value
+		}
+	}
+	return labels, nil
+}
+
+// Wikidata time precisions.
+const (
+	precisionMonth = 10
+	precisionDay   = 11
+)
+
+// parseWikidataTime parses a Wikidata time value such as
+// "+1952-03-11T00:00:00Z" and formats it to its precision. Times less precise
+// than a year are formatted as years.
+func parseWikidataTime(t string, precision int) (EntityDate, bool) {
+	var sign string
+	switch {
+	case strings.HasPrefix(t, "-"):
+		sign = "-"
+		t = t[1:]
+	case strings.HasPrefix(t, "+"):
+		t = t[1:]
+	}
+	if i := strings.Index(t, "T"); i >= 0 {
+		t = t[:i]
+	}
+	parts := strings.Split(t, "-")
+	if len(parts) != 3 {
+		return EntityDate{}, false
+	}
+	year, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return EntityDate{}, false
+	}
+	if sign == "-" {
+		year = -year
+	}
+	d := EntityDate{Year: year, Date: sign + parts[0]}
+	if precision >= precisionMonth && parts[1] != "00" {
+		d.Date += "-" + parts[1]
+		if precision >= precisionDay && parts[2] != "00" {
+			d.Date += "-" + parts[2]
+		}
+	}
+	return d, true
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -3,76 +3,147 @@ package wikipedia
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
 )
 
-var Projects = []string{
-	"en",
-	"fr",
-	"es",
-	"de",
-	"ru",
-	"ja",
-	"nl",
-	"it",
-	"sv",
-	"pl",
-	"vi",
-	"pt",
-	"ar",
-	"zh",
-	"uk",
-	"ro",
-	"bg",
-	"th",
-	"fa",
-}
-
-const wikimediaURL = "https://wikimedia.org/api/rest_v1"
-const wikipediaURL = "https://en.wikipedia.org/api/rest_v1"
+// DefaultPageviewsURL is the base URL of the wikimedia REST API which serves
+// the pageviews metrics.
+const DefaultPageviewsURL = "https://wikimedia.org/api/rest_v1"
 
-var apiURLs = func() map[string]string {
-	ret := make(map[string]string, len(Projects))
-	for _, project := range Projects {
-		ret[project] = fmt.Sprintf("https://%s.wikipedia.org/api/rest_v1", project)
-	}
-	return ret
-}()
+// DefaultUserAgent is the User-Agent sent with requests unless configured
+// otherwise. The wikimedia API policy requires that clients identify
+// themselves with contact information.
+const DefaultUserAgent = "wikifeedia (https://github.com/cockroachlabs/wikifeedia)"
 
-func IsProject(project string) bool {
-	_, isProject := apiURLs[project]
-	return isProject
-}
+// DefaultProjectURLFormat is the format string used to construct the base URL
+// of the REST API for a project from its domain as returned by ProjectDomain.
+const DefaultProjectURLFormat = "https://%s/api/rest_v1"
 
-func apiURL(project string) string {
-	if url, ok := apiURLs[project]; ok {
+// apiURL returns the base URL of the REST API of project. It panics if the
+// project is not among the projects of the client.
+func (c *Client) apiURL(project string) string {
+	if !c.projects.Has(project) {
+		panic(fmt.Errorf("project %q is not allowed", project))
+	}
+	if url, ok := c.apiURLs[project]; ok {
 		return url
 	}
-	panic(fmt.Errorf("project %q is not allowed", project))
+	return strings.TrimSuffix(fmt.Sprintf(c.projectURLFormat, ProjectDomain(project)), "/")
 }
 
 // Client reads from wikipedia.
 type Client struct {
-	cli     http.Client
-	limiter *rate.Limiter
+	cli              *http.Client
+	pageviewsURL     string
+	sitematrixURL    string
+	projects         *Registry
+	projectURLFormat string
+	actionURLFormat  string
+	wikidataURL      string
+	filter           *Filter
+	imagePolicy      ImagePolicy
+	// apiURLs overrides projectURLFormat for individual projects.
+	apiURLs   map[string]string
+	retry     RetryPolicy
+	userAgent string
+	cache     *Cache
+
+	// requestTimeout bounds the duration of each attempt of a request.
+	requestTimeout time.Duration
+
+	pageviewsLimiterConfig LimiterConfig
+	projectLimiterConfig   LimiterConfig
+	pageviewsLimiter       *AdaptiveLimiter
+	// globalLimiter is shared by all requests.
+	globalLimit   rate.Limit
+	globalBurst   int
+	globalLimiter *rate.Limiter
+
+	mu struct {
+		sync.Mutex
+		projectLimiters map[string]*AdaptiveLimiter
+		siteInfos       map[string]*SiteInfo
+	}
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient configures the Client to issue requests with cli.
+func WithHTTPClient(cli *http.Client) Option {
+	return func(c *Client) { c.cli = cli }
+}
+
+// WithPageviewsURL overrides the base URL of the pageviews API.
+func WithPageviewsURL(url string) Option {
+	return func(c *Client) { c.pageviewsURL = strings.TrimSuffix(url, "/") }
+}
+
+// WithProjectURL overrides the base URL of the REST API for project. The
+// project must still be among the projects of the Client.
+func WithProjectURL(project, url string) Option {
+	return func(c *Client) { c.apiURLs[project] = strings.TrimSuffix(url, "/") }
 }
 
-func New() *Client {
-	return &Client{
-		limiter: rate.NewLimiter(75, 5),
+// WithProjectURLFormat overrides the base URL of the REST API for every
+// project using format, which must contain a single %s for the domain of the
+// project.
+func WithProjectURLFormat(format string) Option {
+	return func(c *Client) { c.projectURLFormat = format }
+}
+
+// WithUserAgent overrides the User-Agent sent with each request. It should
+// include a way to contact the operator of the client.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) { c.userAgent = userAgent }
+}
+
+// WithRequestTimeout bounds the duration of each attempt of a request.
+// Zero means no timeout beyond that of the context passed to each call.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *Client) { c.requestTimeout = timeout }
+}
+
+// New creates a new Client which talks to the public wikimedia APIs unless
+// configured otherwise by opts.
+func New(opts ...Option) *Client {
+	c := &Client{
+		cli:                    http.DefaultClient,
+		pageviewsURL:           DefaultPageviewsURL,
+		retry:                  DefaultRetryPolicy,
+		sitematrixURL:          DefaultSitematrixURL,
+		projects:               DefaultRegistry(),
+		projectURLFormat:       DefaultProjectURLFormat,
+		actionURLFormat:        DefaultActionURLFormat,
+		wikidataURL:            DefaultWikidataURL,
+		filter:                 DefaultFilter(),
+		imagePolicy:            DefaultImagePolicy,
+		userAgent:              DefaultUserAgent,
+		apiURLs:                make(map[string]string),
+		pageviewsLimiterConfig: DefaultPageviewsLimiterConfig,
+		projectLimiterConfig:   DefaultProjectLimiterConfig,
+		globalLimit:            DefaultGlobalLimit,
+		globalBurst:            DefaultGlobalBurst,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.pageviewsLimiter = NewAdaptiveLimiter(c.pageviewsLimiterConfig)
+	c.globalLimiter = rate.NewLimiter(c.globalLimit, c.globalBurst)
+	c.mu.projectLimiters = make(map[string]*AdaptiveLimiter)
+	c.mu.siteInfos = make(map[string]*SiteInfo)
+	return c
 }
 
 type PageViewEntry struct {
 	Project string `json:"project"`
-	Article string `json:"article`
+	Article string `json:"article"`
 }
 
 type TopPageviews struct {
@@ -82,12 +153,18 @@ type TopPageviews struct {
 	Month    string `json:"month"`
 	Day      string `json:"day"`
 	Articles []TopPageviewsArticle
+	// Filtered holds the articles which were removed from Articles by the
+	// Filter of the client because they are not suitable for the feed, such
+	// as special pages.
+	Filtered []TopPageviewsArticle `json:"-"`
 }
 
 type TopPageviewsArticle struct {
 	Article string `json:"article"`
 	Views   int    `json:"views"`
 	Rank    int    `json:"rank"`
+	// FilterRule is the name of the rule which removed a filtered article.
+	FilterRule string `json:"-"`
 }
 
 type Article struct {
@@ -124,7 +201,12 @@ type ArticleTitles struct {
 }
 
 type ArticleMediaItem struct {
-	SectionID int              `json:"section_id"`
+	// Title is the title of the file page of the item, e.g.
+	// "File:Example.jpg".
+	Title     string `json:"title"`
+	SectionID int    `json:"section_id"`
+	// LeadImage is true for the image which leads the article.
+	LeadImage bool             `json:"leadImage"`
 	Type      string           `json:"type"`
 	Titles    ArticleTitles    `json:"titles"`
 	Thumbnail ImageMetadata    `json:"thumbnail"`
@@ -156,7 +238,10 @@ type ArticleURLs struct {
 }
 
 type ArticleSummary struct {
-	Type         string `json:"type"`
+	Type     string `json:"type"`
+	Revision string `json:"revision"`
+	// ETag identifies the version of the summary for conditional requests.
+	ETag         string `json:"-"`
 	Title        string `json:"title"`
 	DisplayTitle string `json:"display_title"`
 	Titles       ArticleTitles
@@ -169,114 +254,117 @@ type ArticleSummary struct {
 }
 
 func (c *Client) GetArticle(ctx context.Context, project, articleName string) (Article, error) {
-	summary, err := c.GetArticleSummary(ctx, project, articleName)
-	if err != nil {
-		return Article{}, err
+	a, _, err := c.GetArticleIfChanged(ctx, project, articleName, "")
+	return a, err
+}
+
+// GetArticleIfChanged retrieves an article unless the ETag of its summary
+// matches etag, in which case changed is false and the article is empty.
+func (c *Client) GetArticleIfChanged(
+	ctx context.Context, project, articleName, etag string,
+) (_ Article, changed bool, _ error) {
+	summary, changed, err := c.GetArticleSummaryIfChanged(ctx, project, articleName, etag)
+	if err != nil || !changed {
+		return Article{}, false, err
 	}
 	media, err := c.GetArticleMedia(ctx, project, articleName)
 	if err != nil {
-		return Article{}, err
+		return Article{}, false, err
 	}
 	return Article{
 		Article: articleName,
 		Summary: summary,
 		Media:   media,
-	}, nil
+	}, true, nil
 }
 
 func (c *Client) GetArticleSummary(
 	ctx context.Context, project string, articleName string,
 ) (summary ArticleSummary, err error) {
-	if err = c.limiter.Wait(ctx); err != nil {
-		return summary, err
-	}
-	url := fmt.Sprintf(apiURL(project) + "/page/summary/" + articleName)
-	resp, err := c.cli.Get(url)
-	if err != nil {
-		return summary, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		respBody, _ := ioutil.ReadAll(resp.Body)
-		return summary, fmt.Errorf("Unexpected status code %v: resp %s", resp.StatusCode, respBody)
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
-		return ArticleSummary{}, err
+	summary, _, err = c.GetArticleSummaryIfChanged(ctx, project, articleName, "")
+	return summary, err
+}
+
+// GetArticleSummaryIfChanged retrieves the summary of an article unless its
+// ETag matches etag, in which case changed is false and the summary is
+// empty. An empty etag always retrieves the summary.
+func (c *Client) GetArticleSummaryIfChanged(
+	ctx context.Context, project, articleName, etag string,
+) (summary ArticleSummary, changed bool, err error) {
+	url := c.apiURL(project) + "/page/summary/" + articleName
+	newETag, notModified, err := c.getJSONIfNoneMatch(ctx, c.projectLimiter(project), url, etag, &summary)
+	if err != nil || notModified {
+		return ArticleSummary{}, false, err
 	}
+	summary.ETag = newETag
 	// TODO(ajwerner): clarify the meaning of this field.
 	summary.Timestamp = time.Now().UTC()
-	return summary, nil
+	return summary, true, nil
 }
 
 func (c *Client) GetArticleMedia(
 	ctx context.Context, project, articleName string,
 ) ([]ArticleMediaItem, error) {
-	if err := c.limiter.Wait(ctx); err != nil {
-		return nil, err
-	}
-	url := fmt.Sprintf(apiURL(project) + "/page/media-list/" + articleName)
-	resp, err := c.cli.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+	url := c.apiURL(project) + "/page/media-list/" + articleName
 	var result struct {
 		Items []ArticleMediaItem `json:"items"`
 	}
-	if resp.StatusCode != 200 {
-		respBody, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Unexpected status code %v: resp %s", resp.StatusCode, respBody)
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := c.getJSON(ctx, c.projectLimiter(project), url, &result); err != nil {
 		return nil, err
 	}
 	return result.Items, nil
 }
 
-func (c *Client) FetchTopArticles(ctx context.Context, project string) (*TopPageviews, error) {
-	if err := c.limiter.Wait(ctx); err != nil {
-		return nil, err
-	}
-	now := time.Now().UTC().Add(-24 * time.Hour).Truncate(24 * time.Hour)
-	url := fmt.Sprintf(wikimediaURL+"/metrics/pageviews/top/%s.wikipedia.org/all-access/%04d/%02d/%02d",
-		project, now.Year(), int(now.Month()), now.Day())
-	resp, err := c.cli.Get(url)
-	if err != nil {
-		return nil, err
+// Access is the type of access for which pageviews are counted.
+type Access string
+
+// Access values supported by the pageviews API.
+const (
+	AllAccess Access = "all-access"
+	Desktop   Access = "desktop"
+	MobileApp Access = "mobile-app"
+	MobileWeb Access = "mobile-web"
+)
+
+// ParseAccess parses an Access value.
+func ParseAccess(s string) (Access, error) {
+	switch a := Access(s); a {
+	case AllAccess, Desktop, MobileApp, MobileWeb:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown access %q", s)
 	}
-	defer resp.Body.Close()
+}
+
+// Yesterday returns the most recent day, in UTC, for which a complete set of
+// pageviews may be available.
+func Yesterday() time.Time {
+	return time.Now().UTC().Add(-24 * time.Hour).Truncate(24 * time.Hour)
+}
+
+// FetchTopArticles fetches the top articles of project for yesterday.
+func (c *Client) FetchTopArticles(ctx context.Context, project string) (*TopPageviews, error) {
+	return c.FetchTopArticlesForDate(ctx, project, Yesterday(), AllAccess)
+}
+
+// FetchTopArticlesForDate fetches the top articles of project on the UTC day
+// which contains date, counting views of the specified access type.
+func (c *Client) FetchTopArticlesForDate(
+	ctx context.Context, project string, date time.Time, access Access,
+) (*TopPageviews, error) {
+	date = date.UTC()
+	url := fmt.Sprintf(c.pageviewsURL+"/metrics/pageviews/top/%s/%s/%04d/%02d/%02d",
+		ProjectDomain(project), access, date.Year(), int(date.Month()), date.Day())
 	var result struct {
 		Items []TopPageviews `json:"items"`
 	}
-	if resp.StatusCode != 200 {
-		respBody, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Unexpected status code %v: resp %s", resp.StatusCode, respBody)
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := c.getJSON(ctx, c.pageviewsLimiter, url, &result); err != nil {
 		return nil, err
 	}
 	if len(result.Items) == 0 {
 		return nil, fmt.Errorf("no items found in response")
 	}
 	results := &result.Items[0]
-	results.Articles = filterSpecial(results.Articles)
+	results.Articles, results.Filtered = c.filterTopArticles(ctx, project, results.Articles)
 	return results, nil
 }
-
-func shouldFilter(articleName string) bool {
-	return strings.HasPrefix(articleName, "Special:") ||
-		articleName == "Main_Page" ||
-		strings.HasPrefix(articleName, "Wikipedia:") ||
-		strings.Contains(articleName, "Pagina principale") ||
-		strings.Contains(articleName, "Wikipédia:Accueil principal")
-}
-
-func filterSpecial(top []TopPageviewsArticle) (filtered []TopPageviewsArticle) {
-	filtered = top[:0]
-	for _, a := range top {
-		if !shouldFilter(a.Article) {
-			filtered = append(filtered, a)
-		}
-	}
-	return filtered
-}
@@ -0,0 +1,657 @@
+package wikipedia_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachlabs/wikifeedia/wikipedia"
+	"github.com/cockroachlabs/wikifeedia/wikipedia/wikipediatest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchTopArticles(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddTopArticle("en", "foo", 10)
+	srv.AddTopArticle("en", "Main_Page", 1000)
+	srv.AddTopArticle("en", "Special:Search", 500)
+	srv.AddTopArticle("en", "bar", 20)
+	wiki := srv.NewClient()
+
+	top, err := wiki.FetchTopArticles(context.Background(), "en")
+	require.Nil(t, err)
+	require.Len(t, top.Articles, 2)
+	assert.Equal(t, "bar", top.Articles[0].Article)
+	assert.Equal(t, 20, top.Articles[0].Views)
+	assert.Equal(t, "foo", top.Articles[1].Article)
+	require.Len(t, top.Filtered, 2)
+	assert.Equal(t, "Main_Page", top.Filtered[0].Article)
+	assert.Equal(t, "Special:Search", top.Filtered[1].Article)
+
+	_, err = wiki.FetchTopArticles(context.Background(), "fr")
+	assert.NotNil(t, err)
+
+	// Titles are resolved with the canonical namespaces if the site info is
+	// unavailable.
+	srv.InjectFault("/en.wikipedia.org/w/api.php", wikipediatest.Fault{Status: http.StatusForbidden})
+	top, err = srv.NewClient().FetchTopArticles(context.Background(), "en")
+	require.Nil(t, err)
+	assert.Len(t, top.Articles, 2)
+	assert.Len(t, top.Filtered, 2)
+}
+
+func TestGetArticle(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddArticle("fr", wikipediatest.MakeArticle("fr", "Tour_Eiffel"), 100)
+	wiki := srv.NewClient()
+
+	ctx := context.Background()
+	a, err := wiki.GetArticle(ctx, "fr", "Tour_Eiffel")
+	require.Nil(t, err)
+	assert.Equal(t, "Tour Eiffel", a.Summary.Titles.Normalized)
+	assert.NotEmpty(t, a.Summary.Extract)
+	url, ok := a.GetImageURL()
+	assert.True(t, ok)
+	assert.Equal(t, "https://upload.wikimedia.org/Tour_Eiffel.jpg", url)
+
+	_, err = wiki.GetArticle(ctx, "fr", "Missing")
+	assert.True(t, wikipedia.IsNotFound(err))
+}
+
+func TestFaults(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "foo"), 100)
+	wiki := srv.NewClient(wikipedia.WithRetryPolicy(wikipedia.NoRetries))
+	ctx := context.Background()
+
+	srv.InjectFault("/summary/", wikipediatest.Fault{Status: http.StatusTooManyRequests, Count: 1})
+	_, err := wiki.GetArticleSummary(ctx, "en", "foo")
+	assert.True(t, wikipedia.IsThrottled(err))
+	_, err = wiki.GetArticleSummary(ctx, "en", "foo")
+	assert.Nil(t, err)
+
+	srv.InjectFault("/media-list/", wikipediatest.Fault{Malformed: true})
+	_, err = wiki.GetArticleMedia(ctx, "en", "foo")
+	assert.NotNil(t, err)
+	srv.ClearFaults()
+	_, err = wiki.GetArticleMedia(ctx, "en", "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, srv.Requests("/media-list/foo"))
+}
+
+func TestRetry(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "foo"), 100)
+	wiki := srv.NewClient(wikipedia.WithRetryPolicy(wikipedia.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Multiplier:     2,
+	}))
+	ctx := context.Background()
+
+	srv.InjectFault("/summary/", wikipediatest.Fault{Status: http.StatusServiceUnavailable, Count: 2})
+	_, err := wiki.GetArticleSummary(ctx, "en", "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, srv.Requests("/summary/foo"))
+
+	srv.InjectFault("/summary/", wikipediatest.Fault{Status: http.StatusTooManyRequests, Count: 3})
+	_, err = wiki.GetArticleSummary(ctx, "en", "foo")
+	assert.True(t, wikipedia.IsThrottled(err))
+	assert.Equal(t, 6, srv.Requests("/summary/foo"))
+
+	// Not found is not retried.
+	_, err = wiki.GetArticleSummary(ctx, "en", "bar")
+	assert.True(t, wikipedia.IsNotFound(err))
+	assert.Equal(t, 1, srv.Requests("/summary/bar"))
+
+	// Retry-After is honored.
+	srv.InjectFault("/media-list/", wikipediatest.Fault{
+		Status:     http.StatusTooManyRequests,
+		RetryAfter: "1",
+		Count:      1,
+	})
+	start := time.Now()
+	_, err = wiki.GetArticleMedia(ctx, "en", "foo")
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) >= time.Second)
+
+	// Timeouts are retried.
+	wiki = srv.NewClient(
+		wikipedia.WithRequestTimeout(50*time.Millisecond),
+		wikipedia.WithRetryPolicy(wikipedia.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			Multiplier:     2,
+		}))
+	srv.InjectFault("/summary/", wikipediatest.Fault{Latency: time.Minute, Count: 2})
+	_, err = wiki.GetArticleSummary(ctx, "en", "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 9, srv.Requests("/summary/foo"))
+
+	// Permanent network failures are not retried.
+	closed := wikipediatest.NewServer()
+	closed.Close()
+	wiki = wikipedia.New(
+		wikipedia.WithProjectURLFormat(closed.URL+"/%s"),
+		wikipedia.WithRetryPolicy(wikipedia.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Minute,
+		}))
+	start = time.Now()
+	_, err = wiki.GetArticleSummary(ctx, "en", "foo")
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Minute)
+}
+
+func TestRequestHeadersAndTimeouts(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "foo"), 100)
+	ctx := context.Background()
+
+	wiki := srv.NewClient()
+	_, err := wiki.GetArticleSummary(ctx, "en", "foo")
+	require.Nil(t, err)
+	assert.Equal(t, wikipedia.DefaultUserAgent, srv.LastHeader().Get("User-Agent"))
+
+	const userAgent = "test-agent (test@example.com)"
+	wiki = srv.NewClient(
+		wikipedia.WithUserAgent(userAgent),
+		wikipedia.WithRequestTimeout(10*time.Millisecond),
+		wikipedia.WithRetryPolicy(wikipedia.NoRetries),
+	)
+	_, err = wiki.GetArticleSummary(ctx, "en", "foo")
+	require.Nil(t, err)
+	assert.Equal(t, userAgent, srv.LastHeader().Get("User-Agent"))
+
+	// The per-request timeout stops slow requests.
+	srv.InjectFault("/summary/", wikipediatest.Fault{Latency: time.Minute})
+	start := time.Now()
+	_, err = wiki.GetArticleSummary(ctx, "en", "foo")
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Minute)
+
+	// Cancellation of the context stops in-flight requests.
+	wiki = srv.NewClient()
+	ctx, cancel := context.WithCancel(ctx)
+	go func() { time.Sleep(10 * time.Millisecond); cancel() }()
+	start = time.Now()
+	_, err = wiki.GetArticleSummary(ctx, "en", "foo")
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Minute)
+}
+
+func TestFetchTopArticlesForDate(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddTopArticle("en", "foo", 10)
+	wiki := srv.NewClient()
+
+	access, err := wikipedia.ParseAccess("mobile-web")
+	require.Nil(t, err)
+	date := time.Date(2019, 7, 4, 13, 0, 0, 0, time.UTC)
+	top, err := wiki.FetchTopArticlesForDate(context.Background(), "en", date, access)
+	require.Nil(t, err)
+	assert.Equal(t, "mobile-web", top.Access)
+	assert.Equal(t, "2019", top.Year)
+	assert.Equal(t, "07", top.Month)
+	assert.Equal(t, "04", top.Day)
+	assert.Equal(t, 1, srv.Requests("/en.wikipedia.org/mobile-web/2019/07/04"))
+
+	_, err = wikipedia.ParseAccess("carrier-pigeon")
+	assert.NotNil(t, err)
+}
+
+func TestFetchArticleViews(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	day := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		srv.AddArticleViews("en", "foo", day.AddDate(0, 0, i), 100*(i+1))
+	}
+	wiki := srv.NewClient()
+	ctx := context.Background()
+
+	views, err := wiki.FetchArticleViews(ctx, "en", "foo", wikipedia.Daily,
+		day.AddDate(0, 0, 1), day.AddDate(0, 0, 3))
+	require.Nil(t, err)
+	require.Len(t, views, 3)
+	assert.Equal(t, 200, views[0].Views)
+	ts, err := views[2].Time()
+	require.Nil(t, err)
+	assert.Equal(t, day.AddDate(0, 0, 3), ts)
+
+	views, err = wiki.FetchArticleViews(ctx, "en", "bar", wikipedia.Daily, day, day)
+	assert.Nil(t, err)
+	assert.Len(t, views, 0)
+
+	// Titles are escaped.
+	srv.AddArticleViews("en", "AC/DC", day, 42)
+	views, err = wiki.FetchArticleViews(ctx, "en", "AC/DC", wikipedia.Daily, day, day)
+	require.Nil(t, err)
+	require.Len(t, views, 1)
+	assert.Equal(t, 42, views[0].Views)
+	assert.Equal(t, "AC/DC", views[0].Article)
+}
+
+func TestGetArticleIfChanged(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	a := wikipediatest.MakeArticle("en", "foo")
+	srv.AddArticle("en", a, 100)
+	wiki := srv.NewClient()
+	ctx := context.Background()
+
+	got, changed, err := wiki.GetArticleIfChanged(ctx, "en", "foo", "")
+	require.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "1", got.Summary.Revision)
+	etag := got.Summary.ETag
+	assert.NotEmpty(t, etag)
+
+	_, changed, err = wiki.GetArticleIfChanged(ctx, "en", "foo", etag)
+	require.Nil(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, 1, srv.Requests("/media-list/foo"))
+
+	a.Summary.Revision = "2"
+	srv.AddArticle("en", a, 100)
+	got, changed, err = wiki.GetArticleIfChanged(ctx, "en", "foo", etag)
+	require.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "2", got.Summary.Revision)
+	assert.NotEqual(t, etag, got.Summary.ETag)
+	assert.Equal(t, 2, srv.Requests("/media-list/foo"))
+}
+
+func TestCache(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	a := wikipediatest.MakeArticle("en", "foo")
+	srv.AddArticle("en", a, 100)
+	dir, err := ioutil.TempDir("", "wikipedia-cache")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	cache, err := wikipedia.NewCache(dir, wikipedia.CacheTTLs{
+		TopArticles: time.Hour,
+		Media:       time.Hour,
+	})
+	require.Nil(t, err)
+	wiki := srv.NewClient(wikipedia.WithCache(cache))
+	ctx := context.Background()
+	date := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	// Fresh responses are served without contacting the API.
+	for i := 0; i < 2; i++ {
+		top, err := wiki.FetchTopArticlesForDate(ctx, "en", date, wikipedia.AllAccess)
+		require.Nil(t, err)
+		require.Len(t, top.Articles, 1)
+		assert.Equal(t, "foo", top.Articles[0].Article)
+	}
+	assert.Equal(t, 1, srv.Requests("/metrics/pageviews/top/"))
+
+	// Expired responses are revalidated with their ETag.
+	got, err := wiki.GetArticle(ctx, "en", "foo")
+	require.Nil(t, err)
+	got, err = wiki.GetArticle(ctx, "en", "foo")
+	require.Nil(t, err)
+	assert.Equal(t, a.Summary.Extract, got.Summary.Extract)
+	assert.Equal(t, 2, srv.Requests("/summary/foo"))
+	assert.Equal(t, got.Summary.ETag, srv.LastHeader().Get("If-None-Match"))
+	assert.Equal(t, 1, srv.Requests("/media-list/foo"))
+
+	// The cache is shared by clients using the same directory and honors
+	// conditional requests from callers.
+	wiki = srv.NewClient(wikipedia.WithCache(cache))
+	_, changed, err := wiki.GetArticleIfChanged(ctx, "en", "foo", got.Summary.ETag)
+	require.Nil(t, err)
+	assert.False(t, changed)
+
+	a.Summary.Revision = "2"
+	srv.AddArticle("en", a, 100)
+	got, changed, err = wiki.GetArticleIfChanged(ctx, "en", "foo", got.Summary.ETag)
+	require.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "2", got.Summary.Revision)
+
+	// Responses are served even if they cannot be cached.
+	require.Nil(t, os.RemoveAll(dir))
+	got, err = wiki.GetArticle(ctx, "en", "foo")
+	require.Nil(t, err)
+	assert.Equal(t, a.Summary.Extract, got.Summary.Extract)
+}
+
+func TestRecordReplay(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddArticle("en", wikipediatest.MakeArticle("en", "foo"), 100)
+	dir, err := ioutil.TempDir("", "wikipedia-recording")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	date := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	recorder, err := wikipedia.NewRecordingTransport(dir, srv.Client().Transport)
+	require.Nil(t, err)
+	wiki := srv.NewClient(wikipedia.WithHTTPClient(&http.Client{Transport: recorder}))
+	top, err := wiki.FetchTopArticlesForDate(ctx, "en", date, wikipedia.AllAccess)
+	require.Nil(t, err)
+	a, err := wiki.GetArticle(ctx, "en", "foo")
+	require.Nil(t, err)
+	_, _, err = wiki.GetArticleIfChanged(ctx, "en", "foo", a.Summary.ETag)
+	require.Nil(t, err)
+	srv.Close()
+
+	replayer, err := wikipedia.NewReplayTransport(dir)
+	require.Nil(t, err)
+	wiki = srv.NewClient(wikipedia.WithHTTPClient(&http.Client{Transport: replayer}))
+	replayedTop, err := wiki.FetchTopArticlesForDate(ctx, "en", date, wikipedia.AllAccess)
+	require.Nil(t, err)
+	assert.Equal(t, top.Articles, replayedTop.Articles)
+	replayed, err := wiki.GetArticle(ctx, "en", "foo")
+	require.Nil(t, err)
+	assert.Equal(t, a.Summary.Extract, replayed.Summary.Extract)
+	assert.Equal(t, a.Media, replayed.Media)
+	_, changed, err := wiki.GetArticleIfChanged(ctx, "en", "foo", a.Summary.ETag)
+	require.Nil(t, err)
+	assert.False(t, changed)
+
+	start := time.Now()
+	_, err = wiki.GetArticle(ctx, "en", "bar")
+	assert.True(t, wikipedia.IsNotRecorded(err), "%v", err)
+	assert.True(t, time.Since(start) < 100*time.Millisecond, "unrecorded requests should not be retried")
+}
+
+func TestDiscoverProjects(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddProject(wikipedia.ProjectInfo{Code: "en", Name: "English", LocalName: "English", Direction: "ltr", Articles: 100})
+	srv.AddProject(wikipedia.ProjectInfo{Code: "he", Name: "Hebrew", LocalName: "עברית", Direction: "rtl", Articles: 200})
+	srv.AddProject(wikipedia.ProjectInfo{Code: "tiny", Name: "Tiny", LocalName: "Tiny", Direction: "ltr", Articles: 1})
+	srv.AddArticle("he", wikipediatest.MakeArticle("he", "foo"), 10)
+	wiki := srv.NewClient()
+	ctx := context.Background()
+
+	assert.False(t, wiki.Projects().Has("he"))
+	projects, err := wiki.DiscoverProjects(ctx, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"he", "en"}, projects.Codes())
+	he, ok := projects.Lookup("he")
+	require.True(t, ok)
+	assert.Equal(t, "Hebrew", he.Name)
+	assert.Equal(t, "עברית", he.LocalName)
+	assert.Equal(t, "rtl", he.Direction)
+	assert.Equal(t, 200, he.Articles)
+
+	wiki = srv.NewClient(wikipedia.WithProjects(projects))
+	_, err = wiki.GetArticle(ctx, "he", "foo")
+	require.Nil(t, err)
+	assert.Panics(t, func() { wiki.GetArticle(ctx, "fr", "foo") })
+
+	// Projects whose statistics are unavailable are kept with an unknown
+	// number of articles.
+	srv.InjectFault("/tiny.wikipedia.org/w/api.php", wikipediatest.Fault{Status: http.StatusForbidden})
+	projects, err = srv.NewClient().DiscoverProjects(ctx, 10)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"he", "en", "tiny"}, projects.Codes())
+	tiny, ok := projects.Lookup("tiny")
+	require.True(t, ok)
+	assert.Equal(t, 0, tiny.Articles)
+}
+
+func TestSisterProjects(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.AddProject(wikipedia.ProjectInfo{Code: "en", Articles: 100})
+	srv.AddProject(wikipedia.ProjectInfo{Code: "en.wiktionary", Articles: 100})
+	srv.AddProject(wikipedia.ProjectInfo{Code: "fr.wikivoyage", Articles: 100})
+	srv.AddArticle("en.wiktionary", wikipediatest.MakeArticle("en.wiktionary", "foo"), 100)
+	srv.AddTopArticle("en.wiktionary", "Wiktionary:Main_Page", 1000)
+	srv.AddTopArticle("en.wiktionary", "Wikipedia:bar", 10)
+	wiki := srv.NewClient()
+	ctx := context.Background()
+
+	projects, err := wiki.DiscoverProjects(ctx, 0, wikipedia.Wiktionary, wikipedia.Wikivoyage)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"en.wiktionary", "fr.wikivoyage"}, projects.Codes())
+	p, _ := projects.Lookup("fr.wikivoyage")
+	assert.Equal(t, wikipedia.Wikivoyage, p.Family)
+	assert.Equal(t, "fr", p.Language)
+
+	wiki = srv.NewClient(wikipedia.WithProjects(projects))
+	top, err := wiki.FetchTopArticles(ctx, "en.wiktionary")
+	require.Nil(t, err)
+	assert.Equal(t, "en.wiktionary", top.Project)
+	require.Len(t, top.Articles, 2)
+	assert.Equal(t, "foo", top.Articles[0].Article)
+	assert.Equal(t, "Wikipedia:bar", top.Articles[1].Article)
+	require.Len(t, top.Filtered, 1)
+	a, err := wiki.GetArticle(ctx, "en.wiktionary", "foo")
+	require.Nil(t, err)
+	assert.Equal(t, "https://en.wiktionary.org/wiki/foo", a.Summary.ContentURLs.Desktop.Page)
+}
+
+func TestFilter(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	srv.SetSiteInfo("fr", wikipedia.SiteInfo{
+		MainPage: "Wikipédia:Accueil principal",
+		Namespaces: []wikipedia.Namespace{
+			{ID: -1, Name: "Spécial", Canonical: "Special"},
+			{ID: 4, Name: "Wikipédia", Canonical: "Project"},
+			{ID: 100, Name: "Portail", Canonical: "Portal"},
+		},
+	})
+	for _, title := range []string{
+		"Wikipédia:Accueil_principal", "Spécial:Recherche", "Portail:Football",
+		"Liste_des_communes", "Tour_Eiffel", "Paris",
+	} {
+		srv.AddArticle("fr", wikipediatest.MakeArticle("fr", title), 10)
+	}
+	srv.SetWikidataClasses("Q90", "Q515")
+	dir, err := ioutil.TempDir("", "wikipedia-filter")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "filter.json")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`{"rules": [
+		{"action": "allow", "namespaces": ["Portal"], "projects": ["fr"]},
+		{"action": "deny", "regex": "^Liste_des_"},
+		{"action": "deny", "titles": ["Tour Eiffel"]},
+		{"name": "city", "action": "deny", "wikidata_classes": ["Q515"]},
+		{"action": "deny", "main_page": true},
+		{"action": "deny", "namespaces": ["*"]}
+	]}`), 0644))
+	filter, err := wikipedia.LoadFilter(path)
+	require.Nil(t, err)
+	assert.True(t, filter.NeedsClasses())
+	wiki := srv.NewClient(wikipedia.WithFilter(filter))
+	ctx := context.Background()
+
+	top, err := wiki.FetchTopArticles(ctx, "fr")
+	require.Nil(t, err)
+	var kept []string
+	for _, a := range top.Articles {
+		kept = append(kept, a.Article)
+	}
+	assert.ElementsMatch(t, []string{"Portail:Football", "Paris"}, kept)
+	rules := make(map[string]string)
+	for _, a := range top.Filtered {
+		rules[a.Article] = a.FilterRule
+	}
+	assert.Equal(t, map[string]string{
+		"Wikipédia:Accueil_principal": "main page",
+		"Spécial:Recherche":           "namespace *",
+		"Liste_des_communes":          "regex ^Liste_des_",
+		"Tour_Eiffel":                 "title Tour Eiffel",
+	}, rules)
+
+	a, err := wiki.GetArticle(ctx, "fr", "Paris")
+	require.Nil(t, err)
+	a.Summary.WikibaseItem = "Q90"
+	d, err := wiki.CheckArticle(ctx, "fr", &a)
+	require.Nil(t, err)
+	assert.Equal(t, wikipedia.FilterDecision{Allowed: false, Rule: "city"}, d)
+
+	_, err = wikipedia.NewFilter([]wikipedia.FilterRule{{Action: "maybe", MainPage: true}})
+	assert.NotNil(t, err)
+	_, err = wikipedia.NewFilter([]wikipedia.FilterRule{{Action: wikipedia.Deny}})
+	assert.NotNil(t, err)
+}
+
+func TestGetWikidataEntity(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	want := wikipedia.Entity{
+		ID:          "Q42",
+		Description: "English writer and humorist",
+		Classes:     []wikipedia.EntityClass{{ID: "Q5", Label: "human"}},
+		Coordinates: &wikipedia.Coordinates{Latitude: 51.5, Longitude: -0.12},
+		Dates: []wikipedia.EntityDate{
+			{Kind: "birth", Date: "1952-03-11", Year: 1952},
+			{Kind: "death", Date: "2001-05", Year: 2001},
+		},
+		Image: "Douglas adams portrait cropped.jpg",
+	}
+	srv.SetWikidataEntity(want)
+	srv.SetWikidataEntity(wikipedia.Entity{
+		ID:    "Q1048",
+		Dates: []wikipedia.EntityDate{{Kind: "birth", Date: "-0100", Year: -100}},
+	})
+	wiki := srv.NewClient()
+	ctx := context.Background()
+
+	e, err := wiki.GetWikidataEntity(ctx, "Q42", "en")
+	require.Nil(t, err)
+	assert.Equal(t, &want, e)
+	classes, err := wiki.GetWikidataClasses(ctx, "Q42")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"Q5"}, classes)
+
+	e, err = wiki.GetWikidataEntity(ctx, "Q1048", "en")
+	require.Nil(t, err)
+	assert.Equal(t, []wikipedia.EntityDate{{Kind: "birth", Date: "-0100", Year: -100}}, e.Dates)
+	assert.Empty(t, e.Classes)
+	assert.Nil(t, e.Coordinates)
+
+	_, err = wiki.GetWikidataEntity(ctx, "Q0", "en")
+	assert.NotNil(t, err)
+
+	assert.Equal(t,
+		"https://commons.wikimedia.org/wiki/Special:FilePath/Douglas_adams_portrait_cropped.jpg",
+		wikipedia.CommonsFileURL(want.Image))
+}
+
+func TestSelectImage(t *testing.T) {
+	srv := wikipediatest.NewServer()
+	defer srv.Close()
+	image := func(title string, section int, width, height int, mime string) wikipedia.ArticleMediaItem {
+		return wikipedia.ArticleMediaItem{
+			Title:     title,
+			SectionID: section,
+			Type:      "image",
+			Original: wikipedia.ImageMetadata{
+				Source: "https://upload.wikimedia.org/" + title,
+				Width:  width,
+				Height: height,
+				Mime:   mime,
+			},
+		}
+	}
+	a := wikipediatest.MakeArticle("en", "Oslo")
+	a.Media = []wikipedia.ArticleMediaItem{
+		image("File:Flag_of_Norway.svg", 0, 1100, 800, "image/svg+xml"),
+		image("File:Flag of Oslo.png", 0, 1100, 800, "image/png"),
+		image("File:Oslo_icon.png", 0, 512, 512, "image/png"),
+		image("File:Banner.jpg", 0, 4000, 500, "image/jpeg"),
+		image("File:Tiny.jpg", 0, 100, 80, "image/jpeg"),
+		image("File:Fjord.jpg", 3, 2000, 1500, "image/jpeg"),
+		image("File:Opera.jpg", 1, 0, 0, ""),
+		{Type: "video", Title: "File:Tram.webm"},
+	}
+	srv.AddArticle("en", a, 100)
+	srv.SetImageInfo(wikipedia.ImageInfo{
+		Title:               "File:Opera.jpg",
+		URL:                 "https://upload.wikimedia.org/Opera.jpg",
+		Width:               3000,
+		Height:              2000,
+		Mime:                "image/jpeg",
+		License:             "CC BY 2.0",
+		Artist:              `<a href="//commons.wikimedia.org/wiki/User:Jo">Jo &amp; Ann</a>`,
+		AttributionRequired: true,
+	})
+
+	var titles []string
+	for _, m := range wikipedia.DefaultImagePolicy.Candidates(a.Media) {
+		titles = append(titles, m.Title)
+	}
+	assert.Equal(t, []string{"File:Opera.jpg", "File:Fjord.jpg"}, titles)
+
+	wiki := srv.NewClient()
+	ctx := context.Background()
+	img, err := wiki.SelectImage(ctx, "en", &a)
+	require.Nil(t, err)
+	require.NotNil(t, img)
+	assert.Equal(t, "File:Opera.jpg", img.Title)
+	assert.Equal(t, 3000, img.Width)
+	assert.Equal(t, 640, img.ThumbnailWidth)
+	assert.Equal(t, 426, img.ThumbnailHeight)
+	assert.Contains(t, img.ThumbnailURL, "640px-")
+	assert.Equal(t, "CC BY 2.0", img.License)
+	assert.Equal(t, "Jo & Ann", img.Artist)
+	assert.True(t, img.AttributionRequired)
+
+	// Later sections are ignored if the policy says so.
+	policy := wikipedia.DefaultImagePolicy
+	policy.MaxSection = 0
+	wiki = srv.NewClient(wikipedia.WithImagePolicy(policy))
+	img, err = wiki.SelectImage(ctx, "en", &a)
+	require.Nil(t, err)
+	assert.Nil(t, img)
+
+	// The lead image is preferred.
+	b := wikipediatest.MakeArticle("en", "Bergen")
+	b.Media = append([]wikipedia.ArticleMediaItem{image("File:Bryggen.jpg", 0, 1200, 900, "image/jpeg")}, b.Media...)
+	srv.AddArticle("en", b, 50)
+	img, err = srv.NewClient().SelectImage(ctx, "en", &b)
+	require.Nil(t, err)
+	require.NotNil(t, img)
+	assert.Equal(t, "File:Bergen.jpg", img.Title)
+	assert.Equal(t, "CC BY-SA 4.0", img.License)
+
+	// Candidates whose image info fails to be retrieved are skipped.
+	srv.InjectFault("/en.wikipedia.org/w/api.php", wikipediatest.Fault{Status: http.StatusForbidden, Count: 1})
+	img, err = srv.NewClient().SelectImage(ctx, "en", &b)
+	require.Nil(t, err)
+	require.NotNil(t, img)
+	assert.Equal(t, "File:Bryggen.jpg", img.Title)
+
+	// Files without image info are not candidates.
+	c := wikipediatest.MakeArticle("en", "Trondheim")
+	c.Media = append(c.Media, image("File:Nidaros.jpg", 0, 1200, 900, "image/jpeg"))
+	srv.SetImageInfo(wikipedia.ImageInfo{
+		Title:  "File:Nidaros.jpg",
+		URL:    "https://upload.wikimedia.org/Nidaros.jpg",
+		Width:  1200,
+		Height: 900,
+		Mime:   "image/jpeg",
+	})
+	img, err = srv.NewClient().SelectImage(ctx, "en", &c)
+	require.Nil(t, err)
+	require.NotNil(t, img)
+	assert.Equal(t, "File:Nidaros.jpg", img.Title)
+
+	// An error is returned only if every candidate failed.
+	srv.InjectFault("/en.wikipedia.org/w/api.php", wikipediatest.Fault{Status: http.StatusForbidden})
+	_, err = srv.NewClient().SelectImage(ctx, "en", &b)
+	assert.NotNil(t, err)
+	srv.ClearFaults()
+}
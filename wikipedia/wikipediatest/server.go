@@ -0,0 +1,757 @@
+// Package wikipediatest provides an in-process fake of the wikimedia REST API
+// for use in tests of code which depends on the wikipedia client.
+package wikipediatest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachlabs/wikifeedia/wikipedia"
+)
+
+// Fault describes a failure to inject into responses from the Server.
+type Fault struct {
+	// Latency delays the response.
+	Latency time.Duration
+	// Status, if non-zero, is written instead of the fixture.
+	Status int
+	// RetryAfter, if non-empty, is sent as the Retry-After header.
+	RetryAfter string
+	// Malformed causes the response body to be invalid JSON.
+	Malformed bool
+	// Count is the number of matching requests to which the fault applies.
+	// Zero means that the fault applies to every matching request.
+	Count int
+}
+
+type fault struct {
+	Fault
+	pattern string
+}
+
+type articleKey struct {
+	project, article string
+}
+
+// Server is a fake wikimedia REST API backed by fixtures.
+//
+// The pageviews API is served from the root of the server and the REST API
+// of each project is served under /<domain>, e.g. /en.wikipedia.org.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	top      map[string][]wikipedia.TopPageviewsArticle
+	articles map[articleKey]wikipedia.Article
+	views    map[articleKey]map[time.Time]int
+	projects []wikipedia.ProjectInfo
+	// siteInfos overrides the default site info of projects.
+	siteInfos map[string]wikipedia.SiteInfo
+	classes   map[string][]string
+	entities  map[string]wikipedia.Entity
+	labels    map[string]string
+	// images holds the metadata of files by title.
+	images   map[string]wikipedia.ImageInfo
+	faults   []*fault
+	requests []request
+	// notModified counts responses which were 304 Not Modified.
+	notModified int
+}
+
+type request struct {
+	path   string
+	header http.Header
+}
+
+// NewServer creates and starts a new Server with no fixtures.
+// The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		top:       make(map[string][]wikipedia.TopPageviewsArticle),
+		articles:  make(map[articleKey]wikipedia.Article),
+		views:     make(map[articleKey]map[time.Time]int),
+		siteInfos: make(map[string]wikipedia.SiteInfo),
+		classes:   make(map[string][]string),
+		entities:  make(map[string]wikipedia.Entity),
+		labels:    make(map[string]string),
+		images:    make(map[string]wikipedia.ImageInfo),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Options returns the options which configure a wikipedia.Client to talk to
+// the Server.
+func (s *Server) Options() []wikipedia.Option {
+	return []wikipedia.Option{
+		wikipedia.WithHTTPClient(s.Client()),
+		wikipedia.WithPageviewsURL(s.URL),
+		wikipedia.WithProjectURLFormat(s.URL + "/%s"),
+		wikipedia.WithSitematrixURL(s.URL + "/w/api.php"),
+		wikipedia.WithActionURLFormat(s.URL + "/%s/w/api.php"),
+		wikipedia.WithWikidataURL(s.URL + "/w/api.php"),
+	}
+}
+
+// NewClient creates a wikipedia.Client which talks to the Server. Additional
+// opts are applied after those which point the client at the Server.
+func (s *Server) NewClient(opts ...wikipedia.Option) *wikipedia.Client {
+	return wikipedia.New(append(s.Options(), opts...)...)
+}
+
+// MakeArticle constructs a plausible article fixture with an extract and a
+// single image.
+func MakeArticle(project, name string) wikipedia.Article {
+	title := strings.Replace(name, "_", " ", -1)
+	page := "https://" + wikipedia.ProjectDomain(project) + "/wiki/" + name
+	return wikipedia.Article{
+		Project: project,
+		Article: name,
+		Summary: wikipedia.ArticleSummary{
+			Type:     "standard",
+			Revision: "1",
+			Title:    name,
+			Titles: wikipedia.ArticleTitles{
+				Canonical:  name,
+				Normalized: title,
+				Display:    title,
+			},
+			Lang:    project,
+			Extract: "An article about " + title + ".",
+			ContentURLs: wikipedia.ContentURLs{
+				Desktop: wikipedia.ArticleURLs{Page: page},
+				Mobile:  wikipedia.ArticleURLs{Page: page},
+			},
+		},
+		Media: []wikipedia.ArticleMediaItem{{
+			Title:     "File:" + name + ".jpg",
+			LeadImage: true,
+			Type:      "image",
+			Original: wikipedia.ImageMetadata{
+				Source: "https://upload.wikimedia.org/" + name + ".jpg",
+				Width:  1024,
+				Height: 768,
+				Mime:   "image/jpeg",
+			},
+		}},
+	}
+}
+
+// AddArticle adds a to the fixtures of project and lists it among the top
+// articles with the given number of views. The metadata of the images of a
+// is derived from its media unless set with SetImageInfo.
+func (s *Server) AddArticle(project string, a wikipedia.Article, views int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.articles[articleKey{project, a.Article}] = a
+	for _, m := range a.Media {
+		title := m.FileTitle()
+		if _, ok := s.images[title]; ok || m.Type != "image" || title == "" {
+			continue
+		}
+		s.images[title] = wikipedia.ImageInfo{
+			Title:          title,
+			URL:            m.Original.Source,
+			Width:          m.Original.Width,
+			Height:         m.Original.Height,
+			Mime:           m.Original.Mime,
+			DescriptionURL: "https://commons.wikimedia.org/wiki/" + title,
+			License:        "CC BY-SA 4.0",
+			LicenseURL:     "https://creativecommons.org/licenses/by-sa/4.0",
+			Artist:         "Example",
+		}
+	}
+	top := s.top[project]
+	for i := range top {
+		if top[i].Article == a.Article {
+			top = append(top[:i], top[i+1:]...)
+			break
+		}
+	}
+	top = append(top, wikipedia.TopPageviewsArticle{Article: a.Article, Views: views})
+	sort.SliceStable(top, func(i, j int) bool { return top[i].Views > top[j].Views })
+	for i := range top {
+		top[i].Rank = i + 1
+	}
+	s.top[project] = top
+}
+
+// AddTopArticle lists article among the top articles of project without
+// adding fixtures for its content.
+func (s *Server) AddTopArticle(project, article string, views int) {
+	s.AddArticle(project, wikipedia.Article{Article: article}, views)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.articles, articleKey{project, article})
+}
+
+// ClearTopArticles empties the top articles of project without removing
+// the fixtures for their content.
+func (s *Server) ClearTopArticles(project string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.top[project] = nil
+}
+
+// AddArticleViews records views of article in project in the hour or day
+// which starts at ts.
+func (s *Server) AddArticleViews(project, article string, ts time.Time, views int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := articleKey{project, article}
+	if s.views[k] == nil {
+		s.views[k] = make(map[time.Time]int)
+	}
+	s.views[k][ts.UTC()] = views
+}
+
+// AddProject lists p in the sitematrix. The URL of p is replaced with that of
+// the project on the Server.
+func (s *Server) AddProject(p wikipedia.ProjectInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.URL = s.URL + "/" + wikipedia.ProjectDomain(p.Code)
+	s.projects = append(s.projects, p)
+}
+
+// SetSiteInfo overrides the site info of project. By default, projects have
+// a main page named "Main Page" and a handful of English namespaces.
+func (s *Server) SetSiteInfo(project string, info wikipedia.SiteInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.siteInfos[project] = info
+}
+
+// SetWikidataClasses sets the classes of which the Wikidata item is an
+// instance.
+func (s *Server) SetWikidataClasses(item string, classes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.classes[item] = classes
+}
+
+// SetImageInfo sets the metadata of the file info.Title. Thumbnails are
+// derived from the original.
+func (s *Server) SetImageInfo(info wikipedia.ImageInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.images[info.Title] = info
+}
+
+// SetWikidataEntity sets the facts of the Wikidata item e.ID, including its
+// classes and their labels. Descriptions and labels are served in every
+// language.
+func (s *Server) SetWikidataEntity(e wikipedia.Entity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entities[e.ID] = e
+	var classes []string
+	for _, c := range e.Classes {
+		classes = append(classes, c.ID)
+		s.labels[c.ID] = c.Label
+	}
+	s.classes[e.ID] = classes
+}
+
+// InjectFault causes requests whose path contains pattern to fail as
+// described by f. Faults are applied in the order in which they are injected.
+func (s *Server) InjectFault(pattern string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault{Fault: f, pattern: pattern})
+}
+
+// ClearFaults removes all injected faults.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the number of requests received whose path contains
+// pattern.
+func (s *Server) Requests(pattern string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for _, r := range s.requests {
+		if strings.Contains(r.path, pattern) {
+			n++
+		}
+	}
+	return n
+}
+
+// NotModified returns the number of requests which were answered with 304
+// Not Modified because their ETag matched the content.
+func (s *Server) NotModified() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.notModified
+}
+
+// LastHeader returns the headers of the most recent request.
+func (s *Server) LastHeader() http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.requests) == 0 {
+		return nil
+	}
+	return s.requests[len(s.requests)-1].header
+}
+
+func (s *Server) takeFault(r *http.Request) (f Fault, ok bool) {
+	path := r.URL.EscapedPath()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, request{path: path, header: r.Header})
+	for i, injected := range s.faults {
+		if !strings.Contains(path, injected.pattern) {
+			continue
+		}
+		if injected.Count > 0 {
+			if injected.Count--; injected.Count == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return injected.Fault, true
+	}
+	return Fault{}, false
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	f, haveFault := s.takeFault(r)
+	if f.Latency > 0 {
+		select {
+		case <-time.After(f.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if f.RetryAfter != "" {
+		w.Header().Set("Retry-After", f.RetryAfter)
+	}
+	if haveFault && f.Status != 0 {
+		http.Error(w, http.StatusText(f.Status), f.Status)
+		return
+	}
+	status, body := s.route(path, r.URL.Query())
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if summary, ok := body.(wikipedia.ArticleSummary); ok && summary.Revision != "" {
+		etag := strconv.Quote(summary.Revision)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			s.mu.Lock()
+			s.notModified++
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	buf, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if f.Malformed {
+		buf = buf[:len(buf)/2]
+	}
+	w.Write(buf)
+}
+
+// route returns the fixture for the request path.
+func (s *Server) route(path string, query url.Values) (status int, body interface{}) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if strings.HasPrefix(path, "/metrics/pageviews/top/") {
+		return s.routeTop(parts[3:])
+	}
+	if strings.HasPrefix(path, "/metrics/pageviews/per-article/") {
+		return s.routePerArticle(parts[3:])
+	}
+	if path == "/w/api.php" {
+		switch query.Get("action") {
+		case "wbgetclaims":
+			return s.routeWikidataClaims(query.Get("entity"))
+		case "wbgetentities":
+			return s.routeWikidataEntities(
+				strings.Split(query.Get("ids"), "|"), query.Get("props"), query.Get("languages"))
+		}
+		return s.routeSitematrix()
+	}
+	if len(parts) == 3 && parts[1] == "w" && parts[2] == "api.php" {
+		project, ok := wikipedia.ProjectForDomain(parts[0])
+		if !ok {
+			return http.StatusNotFound, nil
+		}
+		if query.Get("prop") == "imageinfo" {
+			width, _ := strconv.Atoi(query.Get("iiurlwidth"))
+			return s.routeImageInfo(query.Get("titles"), width)
+		}
+		return s.routeSiteinfo(project)
+	}
+	if len(parts) != 4 || parts[1] != "page" {
+		return http.StatusNotFound, nil
+	}
+	project, ok := wikipedia.ProjectForDomain(parts[0])
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	article, err := url.PathUnescape(parts[3])
+	if err != nil {
+		return http.StatusBadRequest, nil
+	}
+	s.mu.Lock()
+	a, ok := s.articles[articleKey{project, article}]
+	s.mu.Unlock()
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	switch parts[2] {
+	case "summary":
+		return http.StatusOK, a.Summary
+	case "media-list":
+		return http.StatusOK, struct {
+			Items []wikipedia.ArticleMediaItem `json:"items"`
+		}{a.Media}
+	default:
+		return http.StatusNotFound, nil
+	}
+}
+
+// routeSitematrix serves the sitematrix of the projects added to the Server.
+func (s *Server) routeSitematrix() (status int, body interface{}) {
+	type site struct {
+		URL  string `json:"url"`
+		Code string `json:"code"`
+	}
+	type language struct {
+		Code      string `json:"code"`
+		Name      string `json:"name"`
+		LocalName string `json:"localname"`
+		Dir       string `json:"dir"`
+		Site      []site `json:"site"`
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matrix := map[string]interface{}{
+		"count":    len(s.projects),
+		"specials": []site{},
+	}
+	for i, p := range s.projects {
+		f, lang := wikipedia.SplitProject(p.Code)
+		siteCode := string(f)
+		if f == wikipedia.Wikipedia {
+			siteCode = "wiki"
+		}
+		matrix[strconv.Itoa(i)] = language{
+			Code:      lang,
+			Name:      p.LocalName,
+			LocalName: p.Name,
+			Dir:       p.Direction,
+			Site:      []site{{URL: p.URL, Code: siteCode}},
+		}
+	}
+	return http.StatusOK, map[string]interface{}{"sitematrix": matrix}
+}
+
+// defaultSiteInfo returns the site info of project unless overridden.
+func defaultSiteInfo(project string) wikipedia.SiteInfo {
+	f, _ := wikipedia.SplitProject(project)
+	projectNamespace := strings.ToUpper(string(f[:1])) + string(f[1:])
+	return wikipedia.SiteInfo{
+		MainPage: "Main Page",
+		Namespaces: []wikipedia.Namespace{
+			{ID: -1, Name: "Special", Canonical: "Special"},
+			{ID: 0},
+			{ID: 1, Name: "Talk", Canonical: "Talk"},
+			{ID: 2, Name: "User", Canonical: "User"},
+			{ID: 4, Name: projectNamespace, Canonical: "Project", Aliases: []string{"WP"}},
+			{ID: 6, Name: "File", Canonical: "File", Aliases: []string{"Image"}},
+			{ID: 10, Name: "Template", Canonical: "Template"},
+			{ID: 14, Name: "Category", Canonical: "Category"},
+			{ID: 100, Name: "Portal", Canonical: "Portal"},
+		},
+	}
+}
+
+// routeSiteinfo serves the site info and statistics of project.
+func (s *Server) routeSiteinfo(project string) (status int, body interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, ok := s.siteInfos[project]
+	if !ok {
+		info = defaultSiteInfo(project)
+	}
+	namespaces := make(map[string]wikipedia.Namespace, len(info.Namespaces))
+	type alias struct {
+		ID    int    `json:"id"`
+		Alias string `json:"alias"`
+	}
+	aliases := []alias{}
+	for _, ns := range info.Namespaces {
+		namespaces[strconv.Itoa(ns.ID)] = ns
+		for _, a := range ns.Aliases {
+			aliases = append(aliases, alias{ns.ID, a})
+		}
+	}
+	var articles int
+	for _, p := range s.projects {
+		if p.Code == project {
+			articles = p.Articles
+		}
+	}
+	return http.StatusOK, map[string]interface{}{
+		"query": map[string]interface{}{
+			"general":          map[string]string{"mainpage": info.MainPage},
+			"namespaces":       namespaces,
+			"namespacealiases": aliases,
+			"statistics":       map[string]int{"articles": articles},
+		},
+	}
+}
+
+// routeImageInfo serves the metadata of the file with title and a thumbnail
+// of the given width.
+func (s *Server) routeImageInfo(title string, width int) (status int, body interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, ok := s.images[title]
+	if !ok {
+		return http.StatusOK, map[string]interface{}{
+			"query": map[string]interface{}{
+				"pages": []interface{}{map[string]interface{}{"title": title, "missing": true}},
+			},
+		}
+	}
+	thumbWidth, thumbHeight := info.Width, info.Height
+	if width > 0 && width < info.Width {
+		thumbWidth, thumbHeight = width, info.Height*width/info.Width
+	}
+	metadata := func(v string) map[string]string { return map[string]string{"value": v} }
+	attributionRequired := "false"
+	if info.AttributionRequired {
+		attributionRequired = "true"
+	}
+	return http.StatusOK, map[string]interface{}{
+		"query": map[string]interface{}{
+			"pages": []interface{}{map[string]interface{}{
+				"title": title,
+				"imageinfo": []interface{}{map[string]interface{}{
+					"url":            info.URL,
+					"width":          info.Width,
+					"height":         info.Height,
+					"mime":           info.Mime,
+					"thumburl":       s.URL + "/thumb/" + strconv.Itoa(thumbWidth) + "px-" + url.PathEscape(title),
+					"thumbwidth":     thumbWidth,
+					"thumbheight":    thumbHeight,
+					"descriptionurl": info.DescriptionURL,
+					"extmetadata": map[string]interface{}{
+						"LicenseShortName":    metadata(info.License),
+						"LicenseUrl":          metadata(info.LicenseURL),
+						"Artist":              metadata(info.Artist),
+						"AttributionRequired": metadata(attributionRequired),
+					},
+				}},
+			}},
+		},
+	}
+}
+
+// routeWikidataClaims serves the instance of claims of item.
+func (s *Server) routeWikidataClaims(item string) (status int, body interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type claim struct {
+		MainSnak struct {
+			DataValue struct {
+				Value struct {
+					ID string `json:"id"`
+				} `json:"value"`
+			} `json:"datavalue"`
+		} `json:"mainsnak"`
+	}
+	claims := []claim{}
+	for _, class := range s.classes[item] {
+		var c claim
+		c.MainSnak.DataValue.Value.ID = class
+		claims = append(claims, c)
+	}
+	return http.StatusOK, map[string]interface{}{
+		"claims": map[string][]claim{"P31": claims},
+	}
+}
+
+// routeWikidataEntities serves the labels of ids if props is "labels" and
+// their descriptions and claims otherwise.
+func (s *Server) routeWikidataEntities(
+	ids []string, props, language string,
+) (status int, body interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	text := func(v string) map[string]interface{} {
+		return map[string]interface{}{language: map[string]string{"language": language, "value": v}}
+	}
+	entities := make(map[string]interface{}, len(ids))
+	for _, id := range ids {
+		missing := map[string]string{"id": id, "missing": ""}
+		if props == "labels" {
+			if label, ok := s.labels[id]; ok {
+				entities[id] = map[string]interface{}{"id": id, "labels": text(label)}
+			} else {
+				entities[id] = missing
+			}
+			continue
+		}
+		e, ok := s.entities[id]
+		if !ok {
+			entities[id] = missing
+			continue
+		}
+		claims := make(map[string][]interface{})
+		for _, c := range e.Classes {
+			claims["P31"] = append(claims["P31"], wikidataClaim(map[string]string{"id": c.ID}))
+		}
+		if e.Coordinates != nil {
+			claims["P625"] = []interface{}{wikidataClaim(map[string]float64{
+				"latitude":  e.Coordinates.Latitude,
+				"longitude": e.Coordinates.Longitude,
+			})}
+		}
+		if e.Image != "" {
+			claims["P18"] = []interface{}{wikidataClaim(e.Image)}
+		}
+		for _, d := range e.Dates {
+			for prop, kind := range wikipedia.DateProperties {
+				if kind == d.Kind {
+					claims[prop] = append(claims[prop], wikidataClaim(wikidataTime(d.Date)))
+				}
+			}
+		}
+		entities[id] = map[string]interface{}{
+			"id":           id,
+			"descriptions": text(e.Description),
+			"claims":       claims,
+		}
+	}
+	return http.StatusOK, map[string]interface{}{"entities": entities}
+}
+
+// wikidataClaim returns a claim of normal rank with value.
+func wikidataClaim(value interface{}) interface{} {
+	return map[string]interface{}{
+		"rank": "normal",
+		"mainsnak": map[string]interface{}{
+			"datavalue": map[string]interface{}{"value": value},
+		},
+	}
+}
+
+// wikidataTime returns the Wikidata time value of a date formatted as in
+// wikipedia.EntityDate.
+func wikidataTime(date string) map[string]interface{} {
+	sign := "+"
+	if strings.HasPrefix(date, "-") {
+		sign, date = "-", date[1:]
+	}
+	parts := strings.Split(date, "-")
+	precision := 8 + len(parts)
+	for len(parts) < 3 {
+		parts = append(parts, "00")
+	}
+	return map[string]interface{}{
+		"time":      sign + strings.Join(parts, "-") + "T00:00:00Z",
+		"precision": precision,
+	}
+}
+
+// routeTop serves {domain}/{access}/{year}/{month}/{day}.
+func (s *Server) routeTop(parts []string) (status int, body interface{}) {
+	if len(parts) != 5 {
+		return http.StatusNotFound, nil
+	}
+	for _, p := range parts[2:] {
+		if _, err := strconv.Atoi(p); err != nil {
+			return http.StatusBadRequest, nil
+		}
+	}
+	project, ok := wikipedia.ProjectForDomain(parts[0])
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	s.mu.Lock()
+	top, ok := s.top[project]
+	top = append([]wikipedia.TopPageviewsArticle(nil), top...)
+	s.mu.Unlock()
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	return http.StatusOK, struct {
+		Items []wikipedia.TopPageviews `json:"items"`
+	}{[]wikipedia.TopPageviews{{
+		Project:  strings.TrimSuffix(parts[0], ".org"),
+		Access:   parts[1],
+		Year:     parts[2],
+		Month:    parts[3],
+		Day:      parts[4],
+		Articles: top,
+	}}}
+}
+
+// routePerArticle serves
+// {domain}/{access}/{agent}/{article}/{granularity}/{start}/{end}.
+func (s *Server) routePerArticle(parts []string) (status int, body interface{}) {
+	if len(parts) != 7 {
+		return http.StatusNotFound, nil
+	}
+	const tsFormat = "2006010215"
+	project, ok := wikipedia.ProjectForDomain(parts[0])
+	if !ok {
+		return http.StatusNotFound, nil
+	}
+	article, err := url.PathUnescape(parts[3])
+	if err != nil {
+		return http.StatusBadRequest, nil
+	}
+	granularity := parts[4]
+	from, fromErr := time.Parse(tsFormat, parts[5])
+	to, toErr := time.Parse(tsFormat, parts[6])
+	if fromErr != nil || toErr != nil {
+		return http.StatusBadRequest, nil
+	}
+	var items []wikipedia.ArticleViews
+	s.mu.Lock()
+	for ts, views := range s.views[articleKey{project, article}] {
+		if ts.Before(from) || ts.After(to) {
+			continue
+		}
+		items = append(items, wikipedia.ArticleViews{
+			Project:     strings.TrimSuffix(parts[0], ".org"),
+			Article:     article,
+			Granularity: granularity,
+			Timestamp:   ts.Format(tsFormat),
+			Access:      parts[1],
+			Agent:       parts[2],
+			Views:       views,
+		})
+	}
+	s.mu.Unlock()
+	if len(items) == 0 {
+		return http.StatusNotFound, nil
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Timestamp < items[j].Timestamp })
+	return http.StatusOK, struct {
+		Items []wikipedia.ArticleViews `json:"items"`
+	}{items}
+}